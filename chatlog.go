@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+const (
+	// LogTimeFormat is the format of the
+	// time stamps that begin log entries.
+	logTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+	// LogDayFormat is the format of the
+	// names of log files, without .log.
+	logDayFormat = "2006-01-02"
+
+	// MaxLogLine is the length of the longest
+	// log line that can be read.
+	maxLogLine = 1 << 20
+)
+
+// A chatLog is the log of the messages written to the window
+// of a channel or user: a directory holding a file for each day,
+// named YYYY-MM-DD.log. Each line of a file is an entry, a time
+// stamp followed by a space and the message as written to the
+// window: <nick> text for a message, * nick text for an action,
+// and a line beginning with =, +, -, or ~ for other events.
+// The continuation lines of multi-line messages begin with a tab.
+type chatLog struct {
+	dir string
+
+	// F is the open log file, and day is its day.
+	f   *os.File
+	day string
+
+	// Last is the time of the latest entry.
+	last time.Time
+}
+
+// LogPath returns the default log directory:
+// velour/log in the XDG state directory.
+func logPath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "velour", "log")
+}
+
+// LogName returns a network or target name as the name
+// of a log directory. Other than letters, digits, dots,
+// and hyphens, its bytes are written as _ followed by
+// two hex digits, as in _23go for #go, so that acme
+// and the plumber take the paths of logs as file names.
+func logName(name string) string {
+	var b strings.Builder
+	for _, c := range []byte(strings.ToLower(name)) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.' && b.Len() > 0, c == '-', c >= 0x80:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// NetworkLogDir returns the log directory of the session's network.
+func (s *session) networkLogDir() string {
+	return filepath.Join(s.opts.logDir, logName(s.name()))
+}
+
+// LogDir returns the log directory of the session's target.
+func (s *session) logDir(target string) string {
+	return filepath.Join(s.networkLogDir(), logName(target))
+}
+
+// OpenLog returns the log in dir. Entries
+// are appended to its files as they are written.
+func openLog(dir string) *chatLog {
+	l := &chatLog{dir: dir}
+	if files := logFiles(dir); len(files) > 0 {
+		last, err := lastLogTime(files[len(files)-1])
+		if err != nil {
+			log.Println("Failed to read log: " + err.Error())
+		}
+		l.last = last
+	}
+	return l
+}
+
+// Write writes an entry with the time t,
+// opening a new file if t is on a new day.
+func (l *chatLog) write(t time.Time, entry string) error {
+	t = t.Local()
+	if day := t.Format(logDayFormat); l.f == nil || day != l.day || l.replaced() {
+		l.close()
+		if err := os.MkdirAll(l.dir, 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(l.dir, day+".log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		l.f, l.day = f, day
+	}
+	if t.After(l.last) {
+		l.last = t
+	}
+	_, err := l.f.WriteString(formatEntry(t, entry))
+	return err
+}
+
+// Replaced returns whether the open log file is no longer
+// at its path, as when velour log import has replaced it,
+// so that entries are written to its replacement.
+func (l *chatLog) replaced() bool {
+	fi, err := l.f.Stat()
+	if err != nil {
+		return true
+	}
+	pi, err := os.Stat(filepath.Join(l.dir, l.day+".log"))
+	return err != nil || !os.SameFile(fi, pi)
+}
+
+// FormatEntry returns the lines of a log entry.
+func formatEntry(t time.Time, entry string) string {
+	entry = strings.ReplaceAll(strings.TrimRight(entry, "\n"), "\n", "\n\t")
+	return t.Format(logTimeFormat) + " " + entry + "\n"
+}
+
+// Close closes the open log file, if any.
+func (l *chatLog) close() {
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// LogMsg returns the log entry of a message,
+// with its tags other than those that only
+// concern its delivery.
+func logMsg(who, text string, tags map[string]string) string {
+	e := logEvent{Kind: "message", Nick: who, Text: text}
+	if strings.HasPrefix(text, actionPrefix) {
+		e.Kind = "action"
+		e.Text = strings.TrimLeft(strings.TrimRight(text[len(actionPrefix):], "\x01"), " ")
+	}
+	for k, v := range tags {
+		switch k {
+		case "time", "label", "batch", irc.ConcatTag:
+			continue
+		}
+		if e.Tags == nil {
+			e.Tags = map[string]string{}
+		}
+		e.Tags[k] = v
+	}
+	return e.entry()
+}
+
+// LogFiles returns the paths of the
+// log files in dir, oldest first.
+func logFiles(dir string) []string {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range ents {
+		day, ok := strings.CutSuffix(e.Name(), ".log")
+		if _, err := time.Parse(logDayFormat, day); ok && err == nil {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files
+}
+
+// A logLine is an entry read from a log.
+type logLine struct {
+	t    time.Time
+	text string
+}
+
+// Tail returns the last n entries of the log.
+// On an error reading a file, it returns the
+// entries read from the files after it.
+func (l *chatLog) tail(n int) ([]logLine, error) {
+	files := logFiles(l.dir)
+	var lines []logLine
+	for i := len(files) - 1; i >= 0 && len(lines) < n; i-- {
+		ls, err := readLog(files[i])
+		if err != nil {
+			return trimLines(lines, n), err
+		}
+		lines = append(ls, lines...)
+	}
+	return trimLines(lines, n), nil
+}
+
+// TrimLines returns the last n of the lines.
+func trimLines(lines []logLine, n int) []logLine {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// NewLogScanner returns a Scanner of the
+// lines of r, up to maxLogLine bytes long.
+func newLogScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxLogLine)
+	return s
+}
+
+// ReadLog returns the entries of the log file.
+// Lines without a valid time stamp are skipped.
+func readLog(path string) ([]logLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []logLine
+	s := newLogScanner(f)
+	for s.Scan() {
+		text := s.Text()
+		if cont, ok := strings.CutPrefix(text, "\t"); ok {
+			if len(lines) > 0 {
+				lines[len(lines)-1].text += "\n" + cont
+			}
+			continue
+		}
+		stamp, text, _ := strings.Cut(text, " ")
+		if t, err := time.Parse(logTimeFormat, stamp); err == nil {
+			lines = append(lines, logLine{t, text})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return lines, fmt.Errorf("%s: %v", path, err)
+	}
+	return lines, nil
+}
+
+// LastLogTime returns the time of
+// the last entry in the log file.
+func lastLogTime(path string) (time.Time, error) {
+	lines, err := readLog(path)
+	if len(lines) == 0 {
+		return time.Time{}, err
+	}
+	return lines[len(lines)-1].t, err
+}
+
+// SearchLogs writes the entries of the logs of the targets
+// in dir that match the regular expression to w, as lines of
+// the form file:line: entry, and returns the number of matches.
+func searchLogs(w io.Writer, dir string, re *regexp.Regexp) (int, error) {
+	targets, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, t := range targets {
+		if !t.IsDir() {
+			continue
+		}
+		for _, path := range logFiles(filepath.Join(dir, t.Name())) {
+			m, err := searchLog(w, path, re)
+			n += m
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func searchLog(w io.Writer, path string, re *regexp.Regexp) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	s := newLogScanner(f)
+	for i := 1; s.Scan(); i++ {
+		if re.MatchString(s.Text()) {
+			if _, err := fmt.Fprintf(w, "%s:%d: %s\n", path, i, s.Text()); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, s.Err()
+}
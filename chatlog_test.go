@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"#go", "_23go"},
+		{"irc.Libera.chat", "irc.libera.chat"},
+		{".hidden", "_2ehidden"},
+		{"..", "_2e."},
+		{"a/b", "a_2fb"},
+		{"a_b", "a_5fb"},
+		{"nick-1", "nick-1"},
+	}
+	for _, test := range tests {
+		if got := logName(test.name); got != test.want {
+			t.Errorf("logName(%q)=%q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestReadLogLongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2006-01-02.log")
+	long := "<bob> " + strings.Repeat("x", 100*1024)
+	data := "2006-01-02T15:04:05.000Z " + long + "\n2006-01-02T15:04:06.000Z <bob> hi\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := readLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0].text != long || lines[1].text != "<bob> hi" {
+		t.Errorf("readLog read %d entries, want the long entry and <bob> hi", len(lines))
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+// A command is a slash command, typed at the
+// prompt of a window as /name followed by
+// its arguments.
+type command struct {
+	// Usage describes the command's
+	// arguments, and help what it does.
+	usage, help string
+
+	// Channel is true if the command acts on a channel:
+	// either a leading channel argument or, if there
+	// is none, the channel of the window.
+	channel bool
+
+	// Min and max are the minimum and maximum
+	// numbers of arguments, not counting the channel.
+	// The last argument is the rest of the line.
+	min, max int
+
+	run func(w *win, ch string, args []string) error
+}
+
+var (
+	// ErrNotChannel is returned by commands that act on
+	// a channel, given in a window of something else.
+	errNotChannel = errors.New("not in a channel window")
+
+	// ErrNotChat is returned by commands that
+	// send to a chat, given in the server window.
+	errNotChat = errors.New("not in a chat window")
+)
+
+// Commands are the slash commands, keyed by name.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"me": {
+			usage: "<action>",
+			help:  "Sends an action to the chat",
+			min:   0,
+			max:   1,
+			// /me is sent from chat windows by send.
+			run: func(w *win, _ string, _ []string) error { return errNotChat },
+		},
+		"join": {
+			usage: "<#channel>[,<#channel>...] [<key>]",
+			help:  "Joins channels, using the key if they have one",
+			min:   1,
+			max:   2,
+			run: func(w *win, _ string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.JOIN, Args: args}
+				return nil
+			},
+		},
+		"part": {
+			usage:   "[<#channel>] [<reason>]",
+			help:    "Leaves the channel",
+			channel: true,
+			min:     0,
+			max:     1,
+			run: func(w *win, ch string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.PART, Args: append([]string{ch}, args...)}
+				return nil
+			},
+		},
+		"msg": {
+			usage: "<target> <message>",
+			help:  "Sends a message to a user or channel",
+			min:   2,
+			max:   2,
+			run: func(w *win, _ string, args []string) error {
+				w.s.say(irc.PRIVMSG, args[0], args[1])
+				return nil
+			},
+		},
+		"query": {
+			usage: "<nick> [<message>]",
+			help:  "Opens a chat window with the user, sending the message if given",
+			min:   1,
+			max:   2,
+			run: func(w *win, _ string, args []string) error {
+				if isChannel(args[0]) {
+					return errors.New(args[0] + " is a channel; use /join")
+				}
+				w.s.getWin(args[0])
+				if len(args) > 1 {
+					w.s.say(irc.PRIVMSG, args[0], args[1])
+				}
+				return nil
+			},
+		},
+		"notice": {
+			usage: "<target> <message>",
+			help:  "Sends a notice to a user or channel",
+			min:   2,
+			max:   2,
+			run: func(w *win, _ string, args []string) error {
+				w.s.say(irc.NOTICE, args[0], args[1])
+				return nil
+			},
+		},
+		"topic": {
+			usage:   "[<#channel>] [<topic>]",
+			help:    "Shows or sets the channel's topic",
+			channel: true,
+			min:     0,
+			max:     1,
+			run: func(w *win, ch string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.TOPIC, Args: append([]string{ch}, args...)}
+				return nil
+			},
+		},
+		"kick": {
+			usage:   "[<#channel>] <nick> [<reason>]",
+			help:    "Removes the user from the channel",
+			channel: true,
+			min:     1,
+			max:     2,
+			run: func(w *win, ch string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.KICK, Args: append([]string{ch}, args...)}
+				return nil
+			},
+		},
+		"ban": {
+			usage:   "[<#channel>] <nick>|<mask>",
+			help:    "Bans the user or mask from the channel",
+			channel: true,
+			min:     1,
+			max:     1,
+			run: func(w *win, ch string, args []string) error {
+				mask := args[0]
+				if !strings.ContainsAny(mask, "!@") {
+					mask += "!*@*"
+				}
+				w.s.client.Out <- irc.Msg{Cmd: irc.MODE, Args: []string{ch, "+b", mask}}
+				return nil
+			},
+		},
+		"invite": {
+			usage: "<nick> [<#channel>]",
+			help:  "Invites the user to the channel",
+			min:   1,
+			max:   2,
+			run: func(w *win, _ string, args []string) error {
+				if len(args) == 1 {
+					ch, err := w.channel()
+					if err != nil {
+						return err
+					}
+					args = append(args, ch)
+				}
+				w.s.client.Out <- irc.Msg{Cmd: irc.INVITE, Args: args}
+				return nil
+			},
+		},
+		"mode": {
+			usage: "[<target>] <modes> [<argument>...]",
+			help:  "Shows or changes the modes of a channel or user",
+			min:   0,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				var fs []string
+				if len(args) > 0 {
+					fs = strings.Fields(args[0])
+				}
+				if len(fs) == 0 || fs[0][0] == '+' || fs[0][0] == '-' {
+					ch, err := w.channel()
+					if err != nil {
+						return err
+					}
+					fs = append([]string{ch}, fs...)
+				}
+				w.s.client.Out <- irc.Msg{Cmd: irc.MODE, Args: fs}
+				return nil
+			},
+		},
+		"whois": {
+			usage: "<nick>",
+			help:  "Shows information about the user in the server window",
+			min:   1,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.WHOIS, Args: args}
+				return nil
+			},
+		},
+		"away": {
+			usage: "[<message>]",
+			help:  "Marks you as away with the message, or as back if none is given",
+			min:   0,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.AWAY, Args: args}
+				return nil
+			},
+		},
+		"nick": {
+			usage: "<nick>",
+			help:  "Changes your nickname",
+			min:   1,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				w.s.client.Out <- irc.Msg{Cmd: irc.NICK, Args: args}
+				return nil
+			},
+		},
+		"quit": {
+			usage: "[<message>]",
+			help:  "Disconnects from the network",
+			min:   0,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				w.s.quit(strings.Join(args, " "))
+				return nil
+			},
+		},
+		"raw": {
+			usage: "<message>",
+			help:  "Sends a raw IRC message to the server",
+			min:   1,
+			max:   1,
+			run: func(w *win, _ string, args []string) error {
+				msg, err := irc.ParseMsg(args[0])
+				if err != nil {
+					return err
+				}
+				w.s.client.Out <- msg
+				return nil
+			},
+		},
+		"help": {
+			usage: "[<command>]",
+			help:  "Lists the commands, or describes the command",
+			min:   0,
+			max:   1,
+			run:   doHelp,
+		},
+	}
+}
+
+// ParseCmd returns the name and arguments of a command
+// line, and whether the line is a command. Lines beginning
+// with // are not commands; they are sent with one / removed.
+func parseCmd(line string) (name, args string, ok bool) {
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") {
+		return "", "", false
+	}
+	line = strings.TrimSpace(line[1:])
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:]), true
+	}
+	return line, "", true
+}
+
+// ChatText returns a line that is not a command as the
+// text to send to a chat, removing the / that escapes
+// a leading /.
+func chatText(line string) string {
+	if strings.HasPrefix(line, "//") {
+		return line[1:]
+	}
+	return line
+}
+
+// RunCmd runs the command in the window,
+// writing any error to the window.
+func (w *win) runCmd(name, text string) {
+	if err := w.execCmd(name, text); err != nil {
+		w.writeMsg("=ERROR: " + err.Error())
+	}
+}
+
+func (w *win) execCmd(name, text string) error {
+	c, ok := commands[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown command /%s; try /help", name)
+	}
+	ch, args, err := w.cmdArgs(name, c, text)
+	if err != nil {
+		return err
+	}
+	return c.run(w, ch, args)
+}
+
+// CmdArgs returns the channel and arguments of the command
+// given in the window with the text, or a usage error.
+func (w *win) cmdArgs(name string, c command, text string) (ch string, args []string, err error) {
+	if c.channel {
+		if f := strings.Fields(text); len(f) > 0 && isChannel(f[0]) {
+			ch = f[0]
+			text = strings.TrimSpace(text[len(f[0]):])
+		} else if ch, err = w.channel(); err != nil {
+			return "", nil, err
+		}
+	}
+	args = splitArgs(text, c.max)
+	if len(args) < c.min || len(args) > c.max {
+		return "", nil, errors.New("usage: /" + name + " " + c.usage)
+	}
+	return ch, args, nil
+}
+
+// SplitArgs splits text into at most n space-separated
+// arguments, the last of which is the rest of the text.
+func splitArgs(text string, n int) []string {
+	var args []string
+	text = strings.TrimSpace(text)
+	for text != "" && len(args) < n-1 {
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			break
+		}
+		args = append(args, text[:i])
+		text = strings.TrimLeft(text[i:], " \t")
+	}
+	if text != "" {
+		args = append(args, text)
+	}
+	return args
+}
+
+// Channel returns the channel of the window.
+func (w *win) channel() (string, error) {
+	if !isChannel(w.target) {
+		return "", errNotChannel
+	}
+	return w.target, nil
+}
+
+// IsChannel returns whether the target is a channel,
+// beginning with one of the channel prefixes #&+!,
+// so that commands take a leading +channel or !channel
+// as their channel, not as a nick or message.
+func isChannel(target string) bool {
+	return target != "" && strings.ContainsAny(target[:1], "#&+!")
+}
+
+// Say sends a PRIVMSG or NOTICE to the target.
+// Without echo-message, the message is written to the
+// target's window, opening it unless the target
+// is a channel, whose window is opened by joining.
+func (s *session) say(cmd, target, text string) {
+	for _, p := range irc.SplitText(cmd, target, text) {
+		s.client.Out <- irc.Msg{Cmd: cmd, Args: []string{target, p}}
+	}
+	if s.client.HasCap(irc.EchoCap) {
+		return
+	}
+	w, ok := s.wins[strings.ToLower(target)]
+	if !ok && isChannel(target) {
+		w = s.serverWin
+	} else if !ok {
+		w = s.getWin(target)
+	}
+	w.writePrivMsg(s.nick, text, time.Now(), nil)
+}
+
+// DoHelp runs the /help command.
+func doHelp(w *win, _ string, args []string) error {
+	if len(args) == 1 {
+		name := strings.TrimPrefix(args[0], "/")
+		c, ok := commands[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("unknown command /%s", name)
+		}
+		w.writeMsg("=/" + name + " " + c.usage + ": " + c.help)
+		return nil
+	}
+	var names []string
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		w.writeMsg("=/" + n + " " + commands[n].usage + ": " + commands[n].help)
+	}
+	w.writeMsg("=Begin a message with // to send it beginning with /")
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCmd(t *testing.T) {
+	tests := []struct {
+		line       string
+		name, args string
+		ok         bool
+	}{
+		{"/join #go", "join", "#go", true},
+		{"/msg  bob \thello there ", "msg", "bob \thello there", true},
+		{"/help", "help", "", true},
+		{"/ part", "part", "", true},
+		{"//join #go", "", "", false},
+		{"hello", "", "", false},
+		{"", "", "", false},
+	}
+	for _, test := range tests {
+		name, args, ok := parseCmd(test.line)
+		if name != test.name || args != test.args || ok != test.ok {
+			t.Errorf("parseCmd(%q)=%q, %q, %v, want %q, %q, %v",
+				test.line, name, args, ok, test.name, test.args, test.ok)
+		}
+	}
+}
+
+func TestChatText(t *testing.T) {
+	tests := []struct{ line, want string }{
+		{"hello", "hello"},
+		{"//join is a command", "/join is a command"},
+		{"///", "//"},
+		{"a // b", "a // b"},
+	}
+	for _, test := range tests {
+		if got := chatText(test.line); got != test.want {
+			t.Errorf("chatText(%q)=%q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		text string
+		n    int
+		want []string
+	}{
+		{"", 2, nil},
+		{"  ", 2, nil},
+		{"a", 2, []string{"a"}},
+		{"a b c", 1, []string{"a b c"}},
+		{"a b c", 2, []string{"a", "b c"}},
+		{" a \t b  c ", 2, []string{"a", "b  c"}},
+		{"a b c", 3, []string{"a", "b", "c"}},
+		{"a b", 5, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		if got := splitArgs(test.text, test.n); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitArgs(%q, %d)=%q, want %q", test.text, test.n, got, test.want)
+		}
+	}
+}
+
+func TestCmdArgs(t *testing.T) {
+	tests := []struct {
+		target, name, text string
+		ch                 string
+		args               []string
+		err                string
+	}{
+		{"#go", "part", "", "#go", nil, ""},
+		{"#go", "part", "bye now", "#go", []string{"bye now"}, ""},
+		{"#go", "part", "#velour bye", "#velour", []string{"bye"}, ""},
+		{"#go", "part", "+local", "+local", nil, ""},
+		{"bob", "part", "", "", nil, errNotChannel.Error()},
+		{"bob", "part", "&local bye", "&local", []string{"bye"}, ""},
+		{"bob", "topic", "#go new topic", "#go", []string{"new topic"}, ""},
+		{"bob", "msg", "alice hi there", "", []string{"alice", "hi there"}, ""},
+		{"bob", "msg", "alice", "", nil, "usage: /msg <target> <message>"},
+		{"bob", "join", "", "", nil, "usage: /join <#channel>[,<#channel>...] [<key>]"},
+		{"bob", "join", "#a,#b key", "", []string{"#a,#b", "key"}, ""},
+		{"bob", "help", "join part", "", []string{"join part"}, ""},
+	}
+	for _, test := range tests {
+		w := &win{target: test.target}
+		ch, args, err := w.cmdArgs(test.name, commands[test.name], test.text)
+		errText := ""
+		if err != nil {
+			errText = err.Error()
+		}
+		if ch != test.ch || !reflect.DeepEqual(args, test.args) || errText != test.err {
+			t.Errorf("/%s %s in %s: got %q, %q, %q, want %q, %q, %q", test.name, test.text, test.target,
+				ch, args, errText, test.ch, test.args, test.err)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/velour/velour/irc"
+)
+
+// A setting is a key and value from
+// the configuration file.
+type setting struct {
+	key, val string
+}
+
+// A profile is the settings of a network
+// from the configuration file, preceded by
+// the settings that apply to all networks.
+type profile []setting
+
+// FlagSettings maps the keys of settings that
+// set flags to the flags' names.
+var flagSettings = map[string]string{
+	"nick":       "n",
+	"alt":        "a",
+	"regain":     "regain",
+	"name":       "f",
+	"pass":       "p",
+	"tls":        "ssl",
+	"trust":      "trust",
+	"bridge":     "bridge",
+	"watch":      "w",
+	"history":    "history",
+	"util":       "u",
+	"notify":     "notify",
+	"log":        "logdir",
+	"scrollback": "scrollback",
+	"files":      "files",
+}
+
+// ConfigName is the path of the configuration file.
+var configName string
+
+// ConfigPath returns the path of the configuration file:
+// $HOME/lib/velour if it exists, and otherwise
+// velour/config in the XDG configuration directory.
+func configPath() string {
+	home, err := os.UserHomeDir()
+	if err == nil {
+		p := filepath.Join(home, "lib", "velour")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "velour", "config")
+}
+
+// ReadConfig reads the configuration file at path,
+// returning its profiles keyed by network name.
+// The profile of the empty name holds the settings
+// that apply to all networks. A missing file is
+// an empty configuration.
+//
+// Each line of the file is a setting: a key followed
+// by its value. Lines beginning with # are comments.
+// A network line begins the profile of the named
+// network, and the settings that follow it, up to the
+// next network line, belong to the network.
+func readConfig(path string) (map[string]profile, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]profile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles := map[string]profile{}
+	name := ""
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key := strings.Fields(line)[0]
+		val := strings.TrimSpace(line[len(key):])
+		switch key {
+		case "network":
+			if val == "" {
+				return nil, fmt.Errorf("%s:%d: missing network name", path, n)
+			}
+			name = val
+			profiles[name] = append(profile{}, profiles[""]...)
+			continue
+		case "server", "port", "sasl", "join", "highlight":
+		default:
+			if _, ok := flagSettings[key]; !ok {
+				return nil, fmt.Errorf("%s:%d: unknown setting %q", path, n, key)
+			}
+		}
+		profiles[name] = append(profiles[name], setting{key, val})
+	}
+	return profiles, s.Err()
+}
+
+// Options returns the options given by the settings of the
+// profile, based on those given on the command line, and the
+// server and port, if set. Settings of flags given on the
+// command line are ignored.
+func (p profile) options() (o *options, server, port string, err error) {
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	o = opts.copy()
+	fs := flag.NewFlagSet("velour", flag.ContinueOnError)
+	o.flags(fs)
+
+	var joins []string
+	for _, s := range p {
+		switch s.key {
+		case "server":
+			server = s.val
+		case "port":
+			port = s.val
+		case "sasl":
+			// The password is the rest of the line,
+			// so that it may contain spaces.
+			fs := strings.Fields(s.val)
+			if len(fs) < 2 {
+				return nil, "", "", errors.New("sasl wants a user name and password")
+			}
+			o.saslUser = fs[0]
+			o.saslPass = strings.TrimSpace(s.val[len(fs[0]):])
+		case "join":
+			joins = append(joins, strings.Fields(s.val)...)
+		case "highlight":
+			o.highlights = append(o.highlights, strings.Fields(s.val)...)
+		default:
+			name := flagSettings[s.key]
+			if given[name] {
+				continue
+			}
+			val := s.val
+			if val == "" && (name == "ssl" || name == "trust") {
+				val = "true"
+			}
+			if err := fs.Set(name, val); err != nil {
+				return nil, "", "", fmt.Errorf("%s: %v", s.key, err)
+			}
+		}
+	}
+	if len(joins) > 0 && !given["j"] {
+		o.join = strings.Join(joins, ",")
+	}
+	return o, server, port, nil
+}
+
+// AddJoins adds a join setting of the channels to the end of
+// the profile of the network in the configuration file at path,
+// removing the channels, compared without regard to case, from
+// the profile's other join settings, so that a channel's new
+// key replaces its old one.
+func addJoins(path, network string, chs []irc.Channel) error {
+	var names []string
+	for _, ch := range chs {
+		names = append(names, ch.String())
+	}
+	return editProfile(path, network, func(lines []string) []string {
+		var edited []string
+		for _, l := range lines {
+			f := strings.Fields(l)
+			if len(f) == 0 || f[0] != "join" {
+				edited = append(edited, l)
+				continue
+			}
+			var keep []string
+			for _, ch := range irc.ParseChannels(strings.Join(f[1:], ",")) {
+				if !hasChannel(chs, ch.Name) {
+					keep = append(keep, ch.String())
+				}
+			}
+			if len(keep) > 0 {
+				indent := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+				edited = append(edited, indent+"join "+strings.Join(keep, " "))
+			}
+		}
+		return append(edited, "\tjoin "+strings.Join(names, " "))
+	})
+}
+
+// HasChannel returns whether the named
+// channel is among chs, regardless of case.
+func hasChannel(chs []irc.Channel, name string) bool {
+	for _, ch := range chs {
+		if strings.EqualFold(ch.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// EditProfile replaces the lines of the profile of the network
+// in the configuration file at path, from its network line through
+// its last setting, with those returned by edit.
+func editProfile(path, network string, edit func(lines []string) []string) error {
+	// A link to the file, as from a dotfiles
+	// repository, is kept when it is replaced.
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	begin, end := -1, -1
+	in := false
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		if k := strings.Fields(l)[0]; k == "network" {
+			if in {
+				break
+			}
+			if in = strings.TrimSpace(l[len(k):]) == network; in {
+				begin = i
+			}
+		}
+		if in {
+			end = i + 1
+		}
+	}
+	if begin < 0 {
+		return fmt.Errorf("%s: no network %s", path, network)
+	}
+	profile := edit(append([]string(nil), lines[begin:end]...))
+	lines = append(lines[:begin], append(profile, lines[end:]...)...)
+	// The file, which may hold passwords, is replaced
+	// so that a failed write does not truncate it.
+	err = writeFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, fi.Mode().Perm())
+}
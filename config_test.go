@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/velour/velour/irc"
+)
+
+func TestAddJoins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	const config = `nick alice
+
+network libera
+	server irc.libera.chat
+	join #go #Secret:old
+	join #velour
+
+network oftc
+	join #secret:other
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := addJoins(path, "libera", []irc.Channel{{Name: "#secret", Key: "new"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := addJoins(path, "libera", []irc.Channel{{Name: "#velour"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `nick alice
+
+network libera
+	server irc.libera.chat
+	join #go
+	join #secret:new
+	join #velour
+
+network oftc
+	join #secret:other
+`
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+
+	profiles, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _, _, err := profiles["libera"].options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.join != "#go,#secret:new,#velour" {
+		t.Errorf("join=%q, want #go,#secret:new,#velour", o.join)
+	}
+
+	if err := addJoins(path, "efnet", []irc.Channel{{Name: "#a"}}); err == nil {
+		t.Error("addJoins to a missing network succeeded")
+	}
+}
+
+func TestAddJoinsReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	link := filepath.Join(dir, "link")
+	if err := os.WriteFile(path, []byte("network libera\n\tsasl alice secret\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := addJoins(link, "libera", []irc.Channel{{Name: "#go"}}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("the link to the configuration file was replaced: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("mode=%v, want 0640", fi.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "network libera\n\tsasl alice secret\n\tjoin #go\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if ents, _ := os.ReadDir(dir); len(ents) != 2 {
+		t.Errorf("got %d files, want the configuration file and its link", len(ents))
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A ctlRequest is a line read from a client of the control
+// socket, and the channel on which its reply is sent.
+type ctlRequest struct {
+	line  string
+	reply chan ctlReply
+}
+
+// A ctlReply is the output of a
+// control request, or its error.
+type ctlReply struct {
+	out string
+	err error
+}
+
+// CtlRequests multiplexes the requests of
+// all clients of the control socket.
+var ctlRequests = make(chan ctlRequest)
+
+// CtlPath returns the default path of the control socket:
+// velour/ctl in $XDG_RUNTIME_DIR or, if it is not set,
+// velour-<uid>/ctl in the temporary directory.
+func ctlPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "velour", "ctl")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("velour-%d", os.Getuid()), "ctl")
+}
+
+// ListenCtl listens on the control socket at path,
+// replacing a stale socket left by a velour that
+// did not exit cleanly, but not one that is in use.
+// The socket's directory must be ours alone.
+func listenCtl(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// Another user could make the directory first, and
+	// replace the socket to read the requests sent to it.
+	if err := checkPrivateDir(dir); err != nil {
+		return nil, err
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, errors.New(path + " is in use by another velour")
+	}
+	os.Remove(path)
+	return net.Listen("unix", path)
+}
+
+// ServeCtl serves clients of the control socket
+// until the listener is closed.
+func serveCtl(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go serveCtlConn(c)
+	}
+}
+
+// ServeCtlConn reads a request from the client,
+// sends it on ctlRequests, and writes the reply:
+// its output, or a line beginning with "error: ".
+func serveCtlConn(c net.Conn) {
+	defer c.Close()
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	r := ctlRequest{line: strings.TrimRight(line, "\r\n"), reply: make(chan ctlReply, 1)}
+	ctlRequests <- r
+	rep := <-r.reply
+	if rep.err != nil {
+		fmt.Fprintln(c, "error:", rep.err)
+		return
+	}
+	io.WriteString(c, rep.out)
+}
+
+// HandleCtl handles a control request: the name of a network,
+// or the empty string for the only one, a tab, and a command
+// and its arguments.
+func handleCtl(line string) (string, error) {
+	network, line, ok := strings.Cut(line, "\t")
+	if !ok {
+		return "", errors.New("malformed request")
+	}
+	verb, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if verb == "status" && network == "" {
+		var b strings.Builder
+		for _, s := range sessions {
+			b.WriteString(s.status())
+		}
+		return b.String(), nil
+	}
+	s, err := findSession(network)
+	if err != nil {
+		return "", err
+	}
+	switch verb {
+	case "status":
+		return s.status(), nil
+	case "reconnect":
+		s.client.Reconnect()
+		return "", nil
+	}
+	if !s.connected {
+		return "", errors.New("not connected to " + s.name())
+	}
+	switch verb {
+	case "join", "part", "nick", "away":
+		return "", s.serverWin.execCmd(verb, args)
+	case "say":
+		return "", s.serverWin.execCmd("msg", args)
+	}
+	return "", fmt.Errorf("unknown command %q; want join, part, say, nick, away, reconnect, or status", verb)
+}
+
+// CtlLine returns the line of a control request
+// of the command and arguments to the network.
+func ctlLine(network string, args []string) string {
+	return network + "\t" + strings.Join(args, " ") + "\n"
+}
+
+// FindSession returns the session of the named network,
+// given as its profile, server, or command line argument,
+// or the only session if the name is empty.
+func findSession(network string) (*session, error) {
+	if network == "" {
+		switch len(sessions) {
+		case 0:
+			return nil, errors.New("no networks")
+		case 1:
+			return sessions[0], nil
+		}
+		return nil, errors.New("connected to several networks; give one with -net")
+	}
+	for _, s := range sessions {
+		if strings.EqualFold(network, s.network) || strings.EqualFold(network, s.server) || strings.EqualFold(network, s.arg) {
+			return s, nil
+		}
+	}
+	return nil, errors.New("no network " + network)
+}
+
+// Status returns a line describing the session's connection,
+// followed by a line for each open chat window.
+func (s *session) status() string {
+	state := "disconnected"
+	if s.connected {
+		state = "connected"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %s\n", s.name(), s.addr, s.nick, state)
+	for _, ch := range s.client.Channels() {
+		fmt.Fprintf(&b, "\t%s\n", ch.Name)
+	}
+	for _, w := range s.wins {
+		if !isChannel(w.target) {
+			fmt.Fprintf(&b, "\t%s\n", w.target)
+		}
+	}
+	return b.String()
+}
+
+// CtlCmd runs the ctl subcommand, which sends a command
+// to a running velour over its control socket, and
+// returns the exit status.
+func ctlCmd(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	network := fs.String("net", "", "the network's profile or server, if velour is connected to several")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: velour ctl [-net <network>] join|part|say|nick|away|reconnect|status [<args>]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+	c, err := net.Dial("unix", *socket)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "velour:", err)
+		return 1
+	}
+	defer c.Close()
+	io.WriteString(c, ctlLine(*network, fs.Args()))
+	out, err := io.ReadAll(c)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "velour:", err)
+		return 1
+	}
+	if msg, ok := strings.CutPrefix(string(out), "error: "); ok {
+		fmt.Fprint(os.Stderr, "velour: "+msg)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHandleCtl(t *testing.T) {
+	defer func(ss []*session) { sessions = ss }(sessions)
+	sessions = []*session{
+		{network: "libera", server: "irc.libera.chat", arg: "libera", connected: true},
+		{server: "irc.oftc.net", arg: "irc.oftc.net:6697"},
+	}
+	tests := []struct{ line, err string }{
+		{"frob", "malformed request"},
+		{"\tfrob", "connected to several networks; give one with -net"},
+		{"efnet\tfrob", "no network efnet"},
+		{"libera\tfrob x", `unknown command "frob"; want join, part, say, nick, away, reconnect, or status`},
+		{"irc.oftc.net\tjoin #go", "not connected to irc.oftc.net"},
+		{strings.TrimSuffix(ctlLine("LIBERA", []string{"frob", "x"}), "\n"), `unknown command "frob"`},
+	}
+	for _, test := range tests {
+		_, err := handleCtl(test.line)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("handleCtl(%q) error=%v, want %s", test.line, err, test.err)
+		}
+	}
+}
+
+func TestFindSession(t *testing.T) {
+	defer func(ss []*session) { sessions = ss }(sessions)
+	libera := &session{network: "libera", server: "irc.libera.chat", arg: "libera"}
+	oftc := &session{server: "irc.oftc.net", arg: "irc.oftc.net:6697"}
+
+	sessions = nil
+	if _, err := findSession(""); err == nil {
+		t.Error("findSession with no sessions succeeded")
+	}
+	sessions = []*session{oftc}
+	if s, err := findSession(""); s != oftc || err != nil {
+		t.Errorf("findSession of the only session=%v, %v, want it", s, err)
+	}
+	sessions = []*session{libera, oftc}
+	if _, err := findSession(""); err == nil {
+		t.Error("findSession with several sessions and no name succeeded")
+	}
+	for name, want := range map[string]*session{
+		"libera":            libera,
+		"Irc.Libera.Chat":   libera,
+		"irc.oftc.net":      oftc,
+		"irc.oftc.net:6697": oftc,
+	} {
+		if s, err := findSession(name); s != want || err != nil {
+			t.Errorf("findSession(%q)=%v, %v, want %v", name, s, err, want)
+		}
+	}
+}
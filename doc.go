@@ -3,15 +3,93 @@ Velour is an IRC client for acme.
 
 Usage:
 
-	velour [options] <server>[:<port>]
+	velour [options] <profile>|<server>[:<port>]|<url> ...
+	velour log export <format> <dir> <file>|<outdir>
+	velour log import <format> <file>|<indir> <dir>
+	velour ctl [-net <network>] <command> [<args>]
 
 The options are:
 
+	-a	Comma-separated alternate nicknames, used if yours is taken
+	-bridge	The nickname of a chat bridge, optionally followed by =format; may be repeated
+	-config	The configuration file
 	-d	Enable debugging
 	-f	Your full name
+	-files	The directory of the file tree through which other programs read and post to windows, or empty for none
+	-history	The number of messages of history to fetch for new chat windows
+	-j	Comma-separated channels to join on every connection, each optionally followed by :key
+	-logdir	The directory of chat logs, or empty to not log; the default is velour/log in the XDG state directory
 	-n	Your nickname (username)
+	-notify	Comma-separated events on which to run the -u program: highlight, private, watch, or a channel for its every message; the default is highlight,private
 	-p	Your password
-	-u	A utility program to send recieved messages via its standard input
+	-plumb	Open the irc:// URLs plumbed to the irc port
+	-regain	The NickServ command, REGAIN or GHOST, used to reclaim your nickname, identifying with the sasl password or, without one, the -p password
+	-scrollback	The number of logged messages to show in new chat windows
+	-socket	The control socket, or empty for none; the default is velour/ctl in $XDG_RUNTIME_DIR
+	-u	A utility program run in the background on the events given by -notify
+	-w	Comma-separated nicknames to watch
+
+Velour reports when a watched nickname comes online or goes offline
+in the server window and in that nickname's chat window, if it is open.
+
+The utility program given by the -u flag is run in the background to notify of
+events, such as with a desktop notification. The -notify flag chooses the
+events: highlight, a message in a room that highlights you; private, a message
+sent to you; watch, a watched nickname coming online or going offline; and the
+name of a room, each message in that room. Your own messages and messages
+replayed from history are not notified. A burst of events is given to a single
+run, and the program is run at most once every five seconds, however many
+networks are open. It is given the nickname of the latest event as its argument;
+the events on its standard input, one JSON object per line, with the fields
+time, event, network, channel, nick, and text; and the fields of the latest
+event in the environment variables VELOUR_EVENT, VELOUR_NETWORK, VELOUR_CHANNEL,
+VELOUR_NICK, and VELOUR_TEXT, with the number of events in VELOUR_COUNT. A run
+that lasts more than 30 seconds is killed.
+
+A chat bridge relays messages from another chat network, prefixing each with the
+nickname of its sender. Velour shows such messages as sent by that nickname,
+followed by the bridge's nickname in parentheses. The format of the prefix is
+one of <> for "<nick> message", the default, [] for "[nick] message", : for
+"nick: message", or a regular expression with two submatches, the nickname and
+the message. For example:
+
+	velour -bridge slackbot -bridge 'matrix=[]' irc.example.net
+
+Settings can also be kept in a configuration file, $HOME/lib/velour if it exists,
+and otherwise velour/config in the XDG configuration directory, such as
+$HOME/.config/velour/config. Each line of the file is a setting, a key followed by
+its value, and lines beginning with # are comments. A network line begins a
+profile, which is used by giving its name in place of the server's address. The
+settings before the first network line apply to every network, and flags given on
+the command line override the settings of the file. For example:
+
+	nick alice
+	name Alice Liddell
+	highlight velour acme
+
+	network libera
+		server irc.libera.chat
+		port 6697
+		tls
+		sasl alice secret
+		join #go #velour
+		bridge slackbot=[]
+
+The settings are server, port, tls, trust, nick, alt, regain, name, pass, util,
+notify, watch, history, log, scrollback, files, and bridge, which set the same as the corresponding flags; sasl,
+a user name and password with which to authenticate using SASL, the password being the rest of the line; join, channels to
+join on every connection, each optionally followed by :key; and highlight, words other than your nickname that highlight
+the messages that contain them. The password given by the -p flag is not recorded
+in the window's dump line; keep passwords in the configuration file instead.
+
+Velour connects to each of the profiles or servers given on the command line,
+so one velour can chat on several networks at once. Each network has its own
+server window and chat windows, and its own settings, taken from its profile, if
+any; flags given on the command line apply to every network. Deleting a network's
+server window disconnects from that network, and velour exits once it has
+disconnected from all of them. For example:
+
+	velour libera irc.oftc.net
 
 Run "velour" without any arguments to get a reminder of the above.
 
@@ -27,9 +105,128 @@ appear for the chatroom, named "/irc/<server>/<room>". The body of the window
 will first contain the list of users in the room and the room's topic, and as velour recieves
 messages for the room, they will be added to the body, tagged with the sender's name
 in angle brackets. If no one has sent any messages for five minutes, velour will add a
-timestamp to the body of the chat window. Like the server window, messages can be sent
-to the room by typing them at the ">" prompt and then typing the Enter key. Velour
-supports one conventional command message: /me.
+timestamp to the body of the chat window. Messages are stamped with the time that
+the server says they were sent, if it supports the server-time capability, so messages
+replayed by a bouncer are preceded by the time at which they were sent. Like the server window, messages can be sent
+to the room by typing them at the ">" prompt and then typing the Enter key.
+
+Lines typed at the prompt that begin with / are commands, which are not sent
+as messages; errors, such as a missing argument, are written to the window.
+A line beginning with // is sent as a message beginning with /. The commands are:
+
+	/me <action>
+	/join <#room>[,<#room>...] [<key>]
+	/part [<#room>] [<reason>]
+	/msg <target> <message>
+	/query <nick> [<message>]
+	/notice <target> <message>
+	/topic [<#room>] [<topic>]
+	/kick [<#room>] <nick> [<reason>]
+	/ban [<#room>] <nick>|<mask>
+	/invite <nick> [<#room>]
+	/mode [<target>] <modes> [<argument>...]
+	/whois <nick>
+	/away [<message>]
+	/nick <nick>
+	/quit [<message>]
+	/raw <message>
+	/help [<command>]
+
+Commands that act on a room act on the window's room unless another is given.
+/help describes the commands. If the server supports the
+echo-message capability, sent messages are only added to the body once the server
+has accepted them; messages that the server rejects are reported in the window.
+If the server supports the draft/multiline extension, several lines pasted or typed
+at the prompt at once are sent as a single message, and such messages from others
+are shown as one block beneath the sender's name.
+While you type at a chat window's prompt, velour tells the room or user that you
+are typing, and the tag of a chat window shows who else is typing in it, if the
+server supports message tags.
+
+Velour logs the messages and events written to each chat window, such as joins,
+parts, and topic changes, to the file <logdir>/<network>/<room>/<date>.log, where
+the network is the name of the network's profile or the server's address. In the
+names of these directories, characters other than letters, digits, dots, and hyphens
+are written as _ and two hex digits, so the log of #go is in _23go. Each
+line of a log begins with the time of the message, such as 2006-01-02T15:04:05.000Z,
+followed by the message as shown in the window: <nick> and the text of a message,
+* nick and the text of an action, or a line beginning with =, +, -, or ~ for
+other events. Messages with IRCv3 tags, such as msgid, are preceded by the tags,
+as in @msgid=abc <nick> text. The continuation lines of multi-line messages begin
+with a tab.
+A new chat window begins with the last messages of its log, as many as given by
+the -scrollback flag, between lines reading "=log" and "=end of log".
+
+The log subcommand converts the log of a room or user, the directory
+<logdir>/<network>/<room>, to and from the logs of other clients. Its formats
+are irssi and weechat, a single log file; znc, a directory of log files named
+by day; and json, a file of JSON objects, one per line, each with the time,
+kind, nick, text, and tags of a message or event. Imported logs are merged into
+the velour log, in order, without repeating messages already logged. For example:
+
+	velour log export irssi $HOME/.local/state/velour/log/libera/_23go go.log
+	velour log import znc $HOME/.znc/users/alice/moddata/log/libera/#go \
+		$HOME/.local/state/velour/log/libera/_23go
+
+Given the -files flag, velour also serves each window as a directory of files,
+so that scripts and other programs can read and post to it without acme: the
+server window as <files>/<network>, and each chat window as
+<files>/<network>/<room>, with names written as in the directories of logs,
+so that the directory of #go is _23go. Each directory holds:
+
+	in	A named pipe; each line written to it is sent as if typed at the
+		window's prompt: a message, a slash command, or, in the server
+		window, a raw IRC message
+	ctl	A named pipe; each line written to it is run as a slash command,
+		without its slash, such as "join #go"
+	out	The messages written to the window, appended as they arrive,
+		each line in the format of a log
+	users	The users in the room, one per line
+	topic	The room's topic
+
+For example:
+
+	echo hello >$HOME/irc/libera/_23go/in
+	tail -f $HOME/irc/libera/_23go/out
+
+A running velour listens for commands on its control socket, which the ctl
+subcommand sends them to, so that the shell and cron jobs can tell it what to do.
+The -net flag of ctl names the network, by its profile or server, and is needed
+only if velour is connected to several. The commands are:
+
+	join <#room>[,<#room>...] [<key>]	Joins the rooms
+	part <#room> [<reason>]	Leaves the room
+	say <target> <message>	Sends the message to the room or user
+	nick <name>	Changes your nickname
+	away [<message>]	Marks you as away, or as back if no message is given
+	reconnect	Reconnects to the server at once
+	status	Prints the network, server, nickname, and connection state,
+		followed by the open rooms and chats, of the network or,
+		without -net, of every network
+
+For example:
+
+	velour ctl -net libera say '#go' 'backup finished'
+
+In place of a profile or server, velour can be given an irc:// or ircs:// URL,
+such as irc://irc.libera.chat/#go or ircs://irc.libera.chat:6697/alice,isnick,
+which connects to the server, using its profile if there is one with that server,
+and joins the channel or opens a chat with the user. An ircs:// URL connects with
+SSL, to port 6697 if no port is given, and a channel's key is given as ?key=key.
+Given the -plumb flag, velour also opens the URLs plumbed to the irc port; give
+it to only one velour, since each reading the port is sent every URL. On a
+network to whose server it is connected, it joins the channel or opens the chat,
+and otherwise it connects to the server. URLs in messages, such as those in topics, can then be opened by
+clicking on them with mouse button 3, given a plumbing rule such as:
+
+	type is text
+	data matches 'ircs?://[^ ]+'
+	plumb to irc
+
+If the server supports the draft/chathistory extension, new chat windows begin with
+the recent history of the room or conversation, and after reconnecting, windows are
+filled in with the messages that were missed while disconnected. A window that
+begins with messages from its log is filled in with the messages after them.
 
 Other velour-specific tag commands:
 
@@ -38,5 +235,35 @@ Other velour-specific tag commands:
 
 	Nick <name>
 		Changes your nickname to the given <name>
+
+	Chat <#room> [<key>]
+		Joins the room, using the key if the room has one
+
+	Autojoin [<#room>[:<key>] ...]
+		Adds the rooms, or all joined rooms if none are given, to those
+		joined on every connection, and saves them to the network's
+		profile in the configuration file
+
+	Search <regexp>
+		Opens a window, named "<logdir>/<network>/+Search", listing the
+		lines of the network's logs that match the regular expression,
+		each preceded by its file and line number, so that it can be
+		opened by clicking on it with mouse button 3
+
+	Reply [<message>]
+		Replies to the selected message, either with the given
+		<message> or with the next message sent from the prompt
+
+	React <reaction>
+		Reacts to the selected message with <reaction>, such as an emoji
+
+	Redact [<reason>]
+		Withdraws the selected message, if the server supports
+		the draft/message-redaction extension
+
+Replies are shown beneath a quote of the message to which they reply,
+reactions are gathered on a line beneath the message to which they react,
+and the text of redacted messages is replaced by a note saying who
+redacted them.
 */
 package main
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+// WinFiles are the files of a window in the file tree,
+// through which other programs can read and post to it.
+// The directory of the server window is <files>/<network>,
+// and that of a chat window is <files>/<network>/<target>.
+// Each holds in and ctl, named pipes from which lines are
+// read; out, to which the window's messages are appended;
+// and, for chat windows, users and topic.
+type winFiles struct {
+	dir string
+
+	// In and ctl are the named pipes, and out the output file.
+	in, ctl, out *os.File
+
+	// Users and topic are the contents
+	// last written to the files of those names.
+	users, topic string
+}
+
+// A fileLine is a line read from a window's in or ctl file.
+type fileLine struct {
+	w    *win
+	ctl  bool
+	text string
+}
+
+// FileLines multiplexes the lines read from the files of all windows.
+var fileLines = make(chan fileLine)
+
+// FilesDir returns the directory of the session's
+// target in the file tree, or that of its server
+// window if target is empty.
+func (s *session) filesDir(target string) string {
+	dir := filepath.Join(s.opts.files, logName(s.name()))
+	if target != "" {
+		dir = filepath.Join(dir, logName(target))
+	}
+	return dir
+}
+
+// OpenFiles creates the window's files in dir, if they
+// do not exist, and starts reading its named pipes.
+func openFiles(w *win, dir string) (*winFiles, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f := &winFiles{dir: dir}
+	var err error
+	f.out, err = os.OpenFile(filepath.Join(dir, "out"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		f.in, err = openFifo(filepath.Join(dir, "in"))
+	}
+	if err == nil {
+		f.ctl, err = openFifo(filepath.Join(dir, "ctl"))
+	}
+	if err != nil {
+		f.close()
+		return nil, err
+	}
+	go readFifo(w, f.in, false)
+	go readFifo(w, f.ctl, true)
+	return f, nil
+}
+
+// OpenFifo opens the named pipe at path, making it
+// if there is none. It is opened for writing too, so
+// that reading it waits for the next writer instead
+// of ending when the last one closes it.
+func openFifo(path string) (*os.File, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeNamedPipe == 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := mkfifo(path); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
+
+// ReadFifo sends the lines read from the named
+// pipe on fileLines until the pipe is closed.
+func readFifo(w *win, f *os.File, ctl bool) {
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fileLines <- fileLine{w: w, ctl: ctl, text: s.Text()}
+	}
+}
+
+// Close closes the files. The named pipes are left
+// in place, so that writers block until velour is
+// started again rather than creating regular files.
+func (f *winFiles) close() {
+	for _, p := range []*os.File{f.in, f.ctl, f.out} {
+		if p != nil {
+			p.Close()
+		}
+	}
+}
+
+// Write appends an entry with the time t to the out file,
+// in the format of the entries of logs.
+func (f *winFiles) write(t time.Time, entry string) {
+	if _, err := f.out.WriteString(formatEntry(t.Local(), entry)); err != nil {
+		log.Println("Failed to write " + f.out.Name() + ": " + err.Error())
+	}
+}
+
+// SetFile replaces the contents of the named file,
+// if they differ from old, and returns the new contents.
+func (f *winFiles) setFile(name, old, text string) string {
+	if text == old {
+		return old
+	}
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(text), 0600); err != nil {
+		log.Println("Failed to write " + name + ": " + err.Error())
+	}
+	return text
+}
+
+// SyncUsers writes the window's users, one per line in
+// sorted order, to its users file, if it has one and they
+// have changed.
+func (w *win) syncUsers() {
+	if w.files == nil || w.target == "" || !w.usersChanged {
+		return
+	}
+	w.usersChanged = false
+	nicks := make([]string, 0, len(w.users))
+	for n := range w.users {
+		nicks = append(nicks, n+"\n")
+	}
+	sort.Strings(nicks)
+	w.files.users = w.files.setFile("users", w.files.users, strings.Join(nicks, ""))
+}
+
+// SetTopic writes the topic to the
+// window's topic file, if it has one.
+func (w *win) setTopic(topic string) {
+	if w.files == nil || w.target == "" {
+		return
+	}
+	w.files.topic = w.files.setFile("topic", w.files.topic, topic+"\n")
+}
+
+// SyncFiles writes the users of each of the session's
+// windows whose users have changed to its files.
+func (s *session) syncFiles() {
+	for _, w := range s.wins {
+		w.syncUsers()
+	}
+}
+
+// HandleFileLine handles a line written to one of the window's
+// named pipes. A line written to ctl is a slash command, without
+// its slash. A line written to in is a message to the window's
+// target, or a raw IRC message in the server window, unless
+// it is a slash command, as typed at the window's prompt.
+func (w *win) handleFileLine(l fileLine) {
+	text := strings.TrimRight(l.text, "\r")
+	if w.files == nil || strings.TrimSpace(text) == "" {
+		// The window has been deleted.
+		return
+	}
+	if !w.s.connected {
+		w.writeMsg("=ERROR: not connected")
+		return
+	}
+	if l.ctl {
+		text = "/" + strings.TrimLeft(text, "/")
+	}
+	name, args, isCmd := parseCmd(text)
+	switch {
+	case isCmd && (!strings.EqualFold("/"+name, meCmd) || w.target == ""):
+		w.runCmd(name, args)
+	case isCmd:
+		w.s.say(irc.PRIVMSG, w.target, actionPrefix+" "+args+"\x01")
+	case w.target == "":
+		msg, err := irc.ParseMsg(text)
+		if err != nil {
+			w.writeMsg("=ERROR: " + err.Error())
+			return
+		}
+		w.s.client.Out <- msg
+	default:
+		w.s.say(irc.PRIVMSG, w.target, chatText(text))
+	}
+}
@@ -0,0 +1,150 @@
+package irc
+
+// IRCv3 batches.
+
+import "time"
+
+const (
+	// BatchTimeout is how long a batch may stay open
+	// before it is delivered as if it had been closed.
+	batchTimeout = 2 * time.Minute
+
+	// MaxBatchMsgs is the number of messages at which
+	// an open batch is delivered as if it had been closed.
+	maxBatchMsgs = 10000
+)
+
+// collectedBatches are the batch types whose messages
+// are collected and delivered together. The messages of
+// other batches, unless nested in one of these types,
+// are delivered as they arrive, and so are their
+// opening and closing BATCH messages.
+var collectedBatches = map[string]bool{
+	"chathistory": true,
+	"netsplit":    true,
+	"netjoin":     true,
+	MultilineCap:  true,
+	LabelCap:      true,
+}
+
+// A Batch is a group of related messages,
+// delimited by BATCH messages, which is
+// delivered as a single message.
+//
+// A complete batch of one of the collected types, such as
+// chathistory, netsplit, or labeled-response, is sent on a
+// Client's In channel as the opening BATCH message with
+// its Batch field set.
+// Batches may be nested; a nested batch is one of the
+// Msgs of its parent, and also has its Batch field set.
+type Batch struct {
+	// Ref is the batch's reference tag.
+	Ref string
+
+	// Type is the batch type, such as chathistory.
+	Type string
+
+	// Params are the batch type's parameters.
+	Params []string
+
+	// Msgs are the messages in the batch, in order.
+	Msgs []Msg
+
+	// opened is when the batch was opened.
+	opened time.Time
+}
+
+// batch adds a message read from the server
+// to any batch to which it belongs, returning
+// the message to deliver and whether there is one.
+// It must only be called by the reading routine.
+func (c *Client) batch(m Msg) (Msg, bool) {
+	if m.Cmd == BATCH && len(m.Args) > 0 && len(m.Args[0]) > 1 {
+		ref := m.Args[0][1:]
+		switch m.Args[0][0] {
+		case '+':
+			if len(m.Args) < 2 {
+				return m, true
+			}
+			if _, nested := c.batches[m.Tags["batch"]]; !nested && !collectedBatches[m.Args[1]] {
+				return m, true
+			}
+			m.Batch = &Batch{Ref: ref, Type: m.Args[1], Params: m.Args[2:], opened: time.Now()}
+			c.batches[ref] = &m
+			return Msg{}, false
+
+		case '-':
+			if _, ok := c.batches[ref]; !ok {
+				return m, true
+			}
+			return c.closeBatch(ref)
+		}
+	}
+	if b, ok := c.batches[m.Tags["batch"]]; ok {
+		b.Batch.Msgs = append(b.Batch.Msgs, m)
+		return Msg{}, false
+	}
+	return m, true
+}
+
+// closeBatch closes the open batch, returning it
+// and true unless it is nested in another open batch,
+// to which it is added.
+func (c *Client) closeBatch(ref string) (Msg, bool) {
+	b := c.batches[ref]
+	delete(c.batches, ref)
+	if p, ok := c.batches[b.Tags["batch"]]; ok {
+		p.Batch.Msgs = append(p.Batch.Msgs, *b)
+		return Msg{}, false
+	}
+	return *b, true
+}
+
+// expireBatches closes the batches that have been open
+// longer than batchTimeout or that hold maxBatchMsgs,
+// as if the server had closed them, returning those to
+// deliver, so that a batch that the server never closes
+// is not kept forever. Batches are closed before those
+// in which they are nested. It must only be called by
+// the reading routine.
+func (c *Client) expireBatches(now time.Time) []Msg {
+	expired := map[string]bool{}
+	for ref, b := range c.batches {
+		if now.Sub(b.Batch.opened) > batchTimeout || len(b.Batch.Msgs) >= maxBatchMsgs {
+			expired[ref] = true
+		}
+	}
+	var ms []Msg
+	for len(expired) > 0 {
+		var ready []string
+		for ref := range expired {
+			if !c.hasNested(ref, expired) {
+				ready = append(ready, ref)
+			}
+		}
+		if len(ready) == 0 {
+			// The batches' tags refer to each other.
+			for ref := range expired {
+				ready = append(ready, ref)
+			}
+		}
+		for _, ref := range ready {
+			delete(expired, ref)
+			if m, ok := c.closeBatch(ref); ok {
+				ms = append(ms, m)
+			}
+		}
+	}
+	return ms
+}
+
+// hasNested returns whether any of the batches
+// in refs is nested in the batch ref.
+func (c *Client) hasNested(ref string, refs map[string]bool) bool {
+	for r := range refs {
+		if b, ok := c.batches[r]; ok && b.Tags["batch"] == ref {
+			return true
+		}
+	}
+	return false
+}
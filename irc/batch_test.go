@@ -0,0 +1,127 @@
+package irc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	c := &Client{batches: make(map[string]*Msg)}
+	lines := []string{
+		":srv BATCH +a chathistory #chan",
+		"@batch=a :x PRIVMSG #chan :one",
+		":y PRIVMSG #chan :live",
+		"@batch=a :z PRIVMSG #chan :two",
+		"@batch=a :srv BATCH +b draft/multiline #chan",
+		"@batch=b :x PRIVMSG #chan :three",
+		"@batch=b :x PRIVMSG #chan :four",
+		":srv BATCH -b",
+		"@batch=a :z PRIVMSG #chan :five",
+		":srv BATCH -a",
+		":srv BATCH -unknown",
+		":srv BATCH +c example/other",
+		"@batch=c :x PRIVMSG #chan :six",
+		":srv BATCH -c",
+	}
+	var got []Msg
+	for _, l := range lines {
+		m, err := ParseMsg(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := c.batch(m); ok {
+			got = append(got, m)
+		}
+	}
+
+	var cmds []string
+	for _, m := range got {
+		cmds = append(cmds, m.Cmd+" "+lastArg(m))
+	}
+	want := []string{
+		"PRIVMSG live",
+		"BATCH #chan",
+		"BATCH -unknown",
+		"BATCH example/other",
+		"PRIVMSG six",
+		"BATCH -c",
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Fatalf("got %v, want %v", cmds, want)
+	}
+
+	b := got[1].Batch
+	if b == nil || b.Ref != "a" || b.Type != "chathistory" || !reflect.DeepEqual(b.Params, []string{"#chan"}) {
+		t.Fatalf("got batch %#v, want chathistory #chan", b)
+	}
+	if len(b.Msgs) != 4 {
+		t.Fatalf("got %d batch messages, want 4", len(b.Msgs))
+	}
+	for i, txt := range []string{"one", "two", "", "five"} {
+		if txt != "" && lastArg(b.Msgs[i]) != txt {
+			t.Errorf("batch message %d is %#v, want %s", i, b.Msgs[i], txt)
+		}
+	}
+	n := b.Msgs[2].Batch
+	if n == nil || n.Type != "draft/multiline" || len(n.Msgs) != 2 ||
+		lastArg(n.Msgs[0]) != "three" || lastArg(n.Msgs[1]) != "four" {
+		t.Errorf("got nested batch %#v, want multiline three, four", n)
+	}
+	if len(c.batches) != 0 {
+		t.Errorf("batches left open: %v", c.batches)
+	}
+}
+
+func lastArg(m Msg) string {
+	if len(m.Args) == 0 {
+		return ""
+	}
+	return m.Args[len(m.Args)-1]
+}
+
+func TestExpireBatches(t *testing.T) {
+	c := &Client{batches: make(map[string]*Msg)}
+	for _, l := range []string{
+		":srv BATCH +a netsplit x y",
+		"@batch=a :srv BATCH +b draft/multiline #chan",
+		"@batch=b :x PRIVMSG #chan :one",
+		"@batch=a :y QUIT :x y",
+	} {
+		m, err := ParseMsg(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m, ok := c.batch(m); ok {
+			t.Fatalf("delivered %v from an open batch", m)
+		}
+	}
+	if ms := c.expireBatches(time.Now()); len(ms) != 0 {
+		t.Fatalf("expired %v before the timeout", ms)
+	}
+	ms := c.expireBatches(time.Now().Add(batchTimeout + time.Second))
+	if len(ms) != 1 || ms[0].Batch == nil || ms[0].Batch.Ref != "a" {
+		t.Fatalf("got %v, want batch a", ms)
+	}
+	if b := ms[0].Batch; len(b.Msgs) != 2 || b.Msgs[1].Batch == nil || b.Msgs[1].Batch.Ref != "b" {
+		t.Errorf("batch a holds %v, want the QUIT and batch b", b.Msgs)
+	}
+	if len(c.batches) != 0 {
+		t.Errorf("batches left open: %v", c.batches)
+	}
+
+	m, _ := ParseMsg("@batch=a :x PRIVMSG #chan :late")
+	if _, ok := c.batch(m); !ok {
+		t.Errorf("message of an expired batch was not delivered")
+	}
+
+	m, _ = ParseMsg(":srv BATCH +big chathistory #chan")
+	c.batch(m)
+	m, _ = ParseMsg("@batch=big :x PRIVMSG #chan :text")
+	for i := 0; i < maxBatchMsgs; i++ {
+		c.batch(m)
+	}
+	if ms := c.expireBatches(time.Now()); len(ms) != 1 || len(ms[0].Batch.Msgs) != maxBatchMsgs {
+		t.Errorf("a full batch was not delivered")
+	}
+}
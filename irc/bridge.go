@@ -0,0 +1,134 @@
+package irc
+
+// Rewriting of messages relayed by chat bridges.
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// A Bridge is a bot that relays messages from
+// another chat network, prefixing each with
+// the nick of its sender.
+//
+// A Client rewrites PRIVMSGs, NOTICEs, and CTCP
+// ACTIONs relayed by a bridge so that they
+// originate from the relayed sender, setting
+// their Relay field to the bridge's nick.
+type Bridge struct {
+	// Nick is the bridge's nick.
+	Nick string
+
+	// Pattern matches the text of a relayed message,
+	// with the sender's nick as its first submatch
+	// and the message as its second.
+	// IRC formatting codes and zero-width spaces
+	// are removed from the text before matching.
+	Pattern *regexp.Regexp
+}
+
+// BridgeFormats are the named formats of relayed messages.
+var BridgeFormats = map[string]*regexp.Regexp{
+	"<>": regexp.MustCompile(`^<([^>]+)> ?(.*)$`),
+	"[]": regexp.MustCompile(`^\[([^\]]+)\] ?(.*)$`),
+	":":  regexp.MustCompile(`^([^\s:]+): ?(.*)$`),
+}
+
+// ParseBridge parses a bridge of the form nick[=format],
+// where format is either one of the BridgeFormats
+// or a regular expression with two submatches.
+// The default format is <>.
+func ParseBridge(s string) (Bridge, error) {
+	nick, format, ok := strings.Cut(s, "=")
+	if nick == "" {
+		return Bridge{}, errors.New("missing bridge nick")
+	}
+	if !ok {
+		format = "<>"
+	}
+	if re, ok := BridgeFormats[format]; ok {
+		return Bridge{Nick: nick, Pattern: re}, nil
+	}
+	re, err := regexp.Compile(format)
+	if err != nil {
+		return Bridge{}, err
+	}
+	if re.NumSubexp() < 2 {
+		return Bridge{}, errors.New("bridge pattern needs two submatches: " + format)
+	}
+	return Bridge{Nick: nick, Pattern: re}, nil
+}
+
+// relay rewrites a message relayed by the bridge,
+// returning whether it was relayed.
+func (b Bridge) relay(m *Msg) bool {
+	if m.Origin != b.Nick || m.Cmd != PRIVMSG && m.Cmd != NOTICE || len(m.Args) < 2 {
+		return false
+	}
+	text := m.Args[len(m.Args)-1]
+	const action = "\x01ACTION "
+	isAction := strings.HasPrefix(text, action)
+	if isAction {
+		text = strings.TrimSuffix(text[len(action):], "\x01")
+	}
+	sm := b.Pattern.FindStringSubmatch(stripFormatting(text))
+	if sm == nil || sm[1] == "" {
+		return false
+	}
+	text = sm[2]
+	if isAction {
+		text = action + text + "\x01"
+	}
+	m.Origin = sm[1]
+	m.User, m.Host = "", ""
+	m.Relay = b.Nick
+	m.Args[len(m.Args)-1] = text
+	return true
+}
+
+// stripFormatting returns s without IRC formatting
+// codes or zero-width spaces.
+func stripFormatting(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0x02, 0x0f, 0x11, 0x16, 0x1d, 0x1e, 0x1f:
+			// bold, reset, monospace, reverse, italic, strikethrough, underline
+		case 0x03: // color: \x03[N[N]][,N[N]]
+			i += colorLen(s[i+1:], isDigit, 2)
+		case 0x04: // hex color: \x04[RRGGBB][,RRGGBB]
+			i += colorLen(s[i+1:], isHexDigit, 6)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.ReplaceAll(b.String(), "\u200b", "")
+}
+
+// colorLen returns the length of the foreground and
+// background colors, of at most max digits each,
+// at the start of s.
+func colorLen(s string, digit func(byte) bool, max int) int {
+	n := digits(s, digit, max)
+	if n > 0 && n+1 < len(s) && s[n] == ',' {
+		if m := digits(s[n+1:], digit, max); m > 0 {
+			n += 1 + m
+		}
+	}
+	return n
+}
+
+func digits(s string, digit func(byte) bool, max int) int {
+	n := 0
+	for n < len(s) && n < max && digit(s[n]) {
+		n++
+	}
+	return n
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
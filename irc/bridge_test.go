@@ -0,0 +1,53 @@
+package irc
+
+import "testing"
+
+func TestBridgeRelay(t *testing.T) {
+	bridge := func(s string) Bridge {
+		b, err := ParseBridge(s)
+		if err != nil {
+			t.Fatalf("ParseBridge(%q) failed: %v", s, err)
+		}
+		return b
+	}
+	tests := []struct {
+		bridge  Bridge
+		raw     string
+		origin  string
+		text    string
+		relayed bool
+	}{
+		{bridge("slack"), ":slack PRIVMSG #c :<alice> hello", "alice", "hello", true},
+		{bridge("slack"), ":slack PRIVMSG #c :<al\u200bice> hel\u200blo", "alice", "hello", true},
+		{bridge("matrix=[]"), ":matrix NOTICE #c :[bob] hi there", "bob", "hi there", true},
+		{bridge("discord=:"), ":discord PRIVMSG #c :carol: yes: no", "carol", "yes: no", true},
+		{bridge("discord=<>"), ":discord PRIVMSG #c :<\x0304,01dave\x0f> hi", "dave", "hi", true},
+		{bridge("discord=<>"), ":discord PRIVMSG #c :<\x04ff0000erin\x04> hi", "erin", "hi", true},
+		{bridge("slack"), ":slack PRIVMSG #c :\x01ACTION <alice> waves\x01", "alice", "\x01ACTION waves\x01", true},
+		{bridge(`tg=^(\w+) says (.*)$`), ":tg PRIVMSG #c :frank says hi", "frank", "hi", true},
+		{bridge("slack"), ":slack PRIVMSG #c :no nick here", "slack", "no nick here", false},
+		{bridge("slack"), ":other PRIVMSG #c :<alice> hello", "other", "<alice> hello", false},
+		{bridge("slack"), ":slack JOIN #c", "slack", "#c", false},
+	}
+	for _, test := range tests {
+		m, err := ParseMsg(test.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		relayed := test.bridge.relay(&m)
+		if relayed != test.relayed || m.Origin != test.origin || lastArg(m) != test.text {
+			t.Errorf("%q relayed by %s: got %t, %q, %q, want %t, %q, %q",
+				test.raw, test.bridge.Nick, relayed, m.Origin, lastArg(m),
+				test.relayed, test.origin, test.text)
+		}
+		if relayed && m.Relay != test.bridge.Nick {
+			t.Errorf("%q relayed by %s: got Relay %q", test.raw, test.bridge.Nick, m.Relay)
+		}
+	}
+
+	for _, s := range []string{"", "=<>", "x=(", "x=(a)"} {
+		if _, err := ParseBridge(s); err == nil {
+			t.Errorf("ParseBridge(%q) succeeded, want error", s)
+		}
+	}
+}
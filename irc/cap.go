@@ -0,0 +1,89 @@
+package irc
+
+// IRCv3 capability negotiation.
+
+import "strings"
+
+// builtinCaps are the capabilities handled by
+// the Client itself, which it always requests.
+var builtinCaps = []string{"batch", "message-tags", "server-time"}
+
+// HasCap returns whether the capability
+// has been enabled on the connection.
+func (c *Client) HasCap(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enabled[name]
+}
+
+// CapValue returns the value advertised by the
+// server for a capability, and whether the
+// server advertised the capability.
+func (c *Client) CapValue(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.available[name]
+	return v, ok
+}
+
+// capSub returns the subcommand of a CAP message
+// and, for LS, whether the list is complete.
+func capSub(m Msg) (sub string, done bool) {
+	if len(m.Args) < 3 {
+		return "", false
+	}
+	sub = strings.ToUpper(m.Args[1])
+	return sub, len(m.Args) == 3 || m.Args[2] != "*"
+}
+
+// capMsg records the capabilities listed in a CAP message,
+// returning its subcommand.
+func (c *Client) capMsg(m Msg) string {
+	sub, _ := capSub(m)
+	if sub == "" {
+		return ""
+	}
+	caps := strings.Fields(m.Args[len(m.Args)-1])
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cp := range caps {
+		name, val := splitString(cp, '=')
+		switch sub {
+		case "LS", "NEW":
+			c.available[name] = val
+		case "DEL":
+			delete(c.available, name)
+			delete(c.enabled, name)
+		case "ACK":
+			if strings.HasPrefix(name, "-") {
+				delete(c.enabled, name[1:])
+			} else {
+				c.enabled[name] = true
+			}
+		}
+	}
+	return sub
+}
+
+// capReq returns a CAP REQ message requesting the wanted
+// capabilities that are available and not yet enabled,
+// and whether there are any such capabilities.
+func (c *Client) capReq() (Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var req []string
+	for _, name := range c.wantCaps {
+		v, ok := c.available[name]
+		if name == SASLCap && !saslPlain(v) {
+			continue
+		}
+		if ok && !c.enabled[name] {
+			req = append(req, name)
+		}
+	}
+	if len(req) == 0 {
+		return Msg{}, false
+	}
+	return Msg{Cmd: CAP, Args: []string{"REQ", strings.Join(req, " ")}}, true
+}
@@ -0,0 +1,202 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapNegotiation(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	type result struct {
+		c   *Client
+		err error
+	}
+	res := make(chan result)
+	go func() {
+		c, err := DialConfig(Config{
+			Addr: s.Addr().String(),
+			Nick: "me",
+			Caps: []string{"away-notify", "extended-join", "missing"},
+		})
+		res <- result{c, err}
+	}()
+
+	sc, err := s.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	r := bufio.NewReader(sc)
+	if l := s.readLine(r); l != "CAP LS :302" {
+		t.Fatalf("got %q, want CAP LS 302", l)
+	}
+	s.readLine(r) // NICK
+	s.readLine(r) // USER
+	sc.Write([]byte(":srv CAP * LS * :away-notify sasl=PLAIN,EXTERNAL\r\n"))
+	sc.Write([]byte(":srv CAP * LS :extended-join multi-prefix server-time\r\n"))
+	if l := s.readLine(r); l != "CAP REQ :server-time away-notify extended-join" {
+		t.Fatalf("got %q, want CAP REQ", l)
+	}
+	sc.Write([]byte(":srv CAP * ACK :server-time away-notify extended-join\r\n"))
+	if l := s.readLine(r); l != "CAP :END" {
+		t.Fatalf("got %q, want CAP END", l)
+	}
+	sc.Write([]byte(":srv 001 me :Welcome\r\n"))
+
+	rs := <-res
+	if rs.err != nil {
+		t.Fatal(rs.err)
+	}
+	c := rs.c
+	defer c.close()
+	go func() {
+		for range c.In {
+		}
+	}()
+	for _, name := range []string{"away-notify", "extended-join"} {
+		if !c.HasCap(name) {
+			t.Errorf("HasCap(%q)=false, want true", name)
+		}
+	}
+	if c.HasCap("multi-prefix") {
+		t.Errorf("HasCap(multi-prefix)=true, want false")
+	}
+	if v, ok := c.CapValue("sasl"); v != "PLAIN,EXTERNAL" || !ok {
+		t.Errorf("CapValue(sasl)=%q,%v, want PLAIN,EXTERNAL,true", v, ok)
+	}
+
+	sc.Write([]byte(":srv CAP me DEL :away-notify\r\n:srv CAP me NEW :missing\r\n"))
+	if l := s.readLine(r); l != "CAP REQ :missing" {
+		t.Errorf("got %q, want CAP REQ missing", l)
+	}
+	if c.HasCap("away-notify") {
+		t.Errorf("HasCap(away-notify)=true after DEL, want false")
+	}
+}
+
+func TestSASL(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	errc := make(chan error)
+	go func() {
+		c, err := DialConfig(Config{
+			Addr:     s.Addr().String(),
+			Nick:     "me",
+			SASLUser: "user",
+			SASLPass: "pass",
+		})
+		if err == nil {
+			c.close()
+		}
+		errc <- err
+	}()
+
+	sc, err := s.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	r := bufio.NewReader(sc)
+	s.readLine(r) // CAP LS
+	s.readLine(r) // NICK
+	s.readLine(r) // USER
+	sc.Write([]byte(":srv CAP * LS :sasl=EXTERNAL,PLAIN\r\n"))
+	if l := s.readLine(r); l != "CAP REQ :sasl" {
+		t.Fatalf("got %q, want CAP REQ sasl", l)
+	}
+	sc.Write([]byte(":srv CAP * ACK :sasl\r\n"))
+	if l := s.readLine(r); l != "AUTHENTICATE :PLAIN" {
+		t.Fatalf("got %q, want AUTHENTICATE PLAIN", l)
+	}
+	sc.Write([]byte("AUTHENTICATE +\r\n"))
+	if l := s.readLine(r); l != "AUTHENTICATE :dXNlcgB1c2VyAHBhc3M=" {
+		t.Fatalf("got %q, want AUTHENTICATE with credentials", l)
+	}
+	sc.Write([]byte(":srv 904 me :SASL authentication failed\r\n"))
+	if err := <-errc; err == nil {
+		t.Errorf("DialConfig succeeded, want SASL failure")
+	}
+}
+
+func TestSASLNak(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	type result struct {
+		c   *Client
+		err error
+	}
+	rc := make(chan result)
+	go func() {
+		c, err := DialConfig(Config{
+			Addr:     s.Addr().String(),
+			Nick:     "me",
+			SASLUser: "user",
+			SASLPass: "pass",
+		})
+		rc <- result{c, err}
+	}()
+
+	sc, err := s.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	r := bufio.NewReader(sc)
+	s.readLine(r) // CAP LS
+	s.readLine(r) // NICK
+	s.readLine(r) // USER
+	sc.Write([]byte(":srv CAP * LS :sasl\r\n"))
+	s.readLine(r) // CAP REQ
+	sc.Write([]byte(":srv CAP * NAK :sasl\r\n"))
+	if l := s.readLine(r); l != "CAP :END" {
+		t.Fatalf("got %q, want CAP END", l)
+	}
+	sc.Write([]byte(":srv 001 me :Welcome\r\n"))
+	res := <-rc
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.c.close()
+	if res.c.Authenticated {
+		t.Errorf("Authenticated is true, want false")
+	}
+}
+
+func TestAuthenticatePlain(t *testing.T) {
+	ms := authenticatePlain("u", strings.Repeat("p", 296))
+	if len(ms) != 2 || len(ms[0].Args[0]) != maxAuthenticate || ms[1].Args[0] != "+" {
+		t.Errorf("got %v, want a full message and +", ms)
+	}
+}
+
+func TestCapNewAfterClose(t *testing.T) {
+	sc, cc := net.Pipe()
+	out := make(chan Msg)
+	c := &Client{
+		conn:      cc,
+		Out:       out,
+		pings:     make(map[string]time.Time),
+		isupport:  make(map[string]string),
+		wantCaps:  []string{"missing"},
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+		batches:   make(map[string]*Msg),
+		capNew:    make(chan struct{}, 1),
+	}
+	close(out)
+	done := make(chan bool)
+	go func() {
+		c.readMsgs(make(chan error, 10), make(chan Msg, 10))
+		done <- true
+	}()
+	sc.Write([]byte(":srv CAP me NEW :missing\r\n"))
+	sc.Close()
+	<-done
+}
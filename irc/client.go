@@ -5,7 +5,9 @@ import (
 	"crypto/tls"
 	"errors"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +19,14 @@ type Client struct {
 	// is connected.
 	Server string
 
+	// Nick is the nick name with which the
+	// client registered.
+	Nick string
+
+	// Authenticated is whether the client
+	// authenticated with SASL while registering.
+	Authenticated bool
+
 	// In is a channel of all incoming messages
 	// from the server.
 	In <-chan Msg
@@ -27,38 +37,118 @@ type Client struct {
 	// Errors is a channel of all read or write errors.
 	Errors <-chan error
 
-	// bridgeNick is the nick of a chat bridge to strip off.
-	bridgeNick string
+	// bridges are the chat bridges whose
+	// relayed messages are rewritten.
+	bridges []Bridge
+
+	// mu protects the lag measurement fields below,
+	// which are updated by the reading routine.
+	mu sync.Mutex
+
+	// pings maps the tokens of outstanding
+	// keepalive PINGs to the time they were sent.
+	pings map[string]time.Time
+
+	// lag is the most recently measured round-trip
+	// time, and avgLag is its running average.
+	lag, avgLag time.Duration
+
+	// isupport maps the tokens advertised by the
+	// server in RPL_ISUPPORT to their values.
+	isupport map[string]string
+
+	// wantCaps are the capabilities to request.
+	wantCaps []string
+
+	// available maps the capabilities advertised
+	// by the server to their values, and enabled
+	// is the set of enabled capabilities.
+	available map[string]string
+	enabled   map[string]bool
+
+	// capNew is signaled by the reading routine when
+	// the server offers new capabilities, so that the
+	// writing routine requests them. Unlike Out, it is
+	// never closed, so a late CAP NEW cannot panic.
+	capNew chan struct{}
+
+	// batches are the open batches being
+	// collected, keyed by reference tag.
+	// They are only used by the reading routine.
+	batches map[string]*Msg
 }
 
 // Dial connects to a remote IRC server.
 func Dial(server, nick, fullname, pass, bridgeNick string) (*Client, error) {
-	c, err := net.Dial("tcp", server)
-	if err != nil {
-		return nil, err
+	return DialConfig(Config{
+		Addr:     server,
+		Nick:     nick,
+		FullName: fullname,
+		Pass:     pass,
+		Bridges:  bridges(bridgeNick),
+	})
+}
+
+// bridges returns the bridges for a bridge nick
+// given to Dial, which relays messages in the
+// <nick> format.
+func bridges(bridgeNick string) []Bridge {
+	if bridgeNick == "" {
+		return nil
 	}
-	return dial(c, nick, fullname, pass, bridgeNick)
+	return []Bridge{{Nick: bridgeNick, Pattern: BridgeFormats["<>"]}}
 }
 
 // DialSSL connects to a remote IRC server using SSL.
 func DialSSL(server, nick, fullname, pass, bridgeNick string, trust bool) (*Client, error) {
-	c, err := tls.Dial("tcp", server, &tls.Config{InsecureSkipVerify: trust})
+	return DialConfig(Config{
+		Addr:     server,
+		Nick:     nick,
+		FullName: fullname,
+		Pass:     pass,
+		SSL:      true,
+		TrustSSL: trust,
+		Bridges:  bridges(bridgeNick),
+	})
+}
+
+// DialConfig connects to the remote IRC server
+// described by the Config.
+func DialConfig(cfg Config) (*Client, error) {
+	var c net.Conn
+	var err error
+	if cfg.SSL {
+		c, err = tls.Dial("tcp", cfg.Addr, &tls.Config{InsecureSkipVerify: cfg.TrustSSL})
+	} else {
+		c, err = net.Dial("tcp", cfg.Addr)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return dial(c, nick, fullname, pass, bridgeNick)
+	return dial(c, cfg)
 }
 
-func dial(conn net.Conn, nick, fullname, pass, bridgeNick string) (*Client, error) {
+func dial(conn net.Conn, cfg Config) (*Client, error) {
 	messagesIn := make(chan Msg, 0)
 	messagesOut := make(chan Msg, 0)
 	errChan := make(chan error)
 	c := &Client{
-		conn:       conn,
-		In:         messagesIn,
-		Out:        messagesOut,
-		Errors:     errChan,
-		bridgeNick: bridgeNick,
+		conn:      conn,
+		In:        messagesIn,
+		Out:       messagesOut,
+		Errors:    errChan,
+		bridges:   cfg.Bridges,
+		pings:     make(map[string]time.Time),
+		isupport:  make(map[string]string),
+		wantCaps:  append(builtinCaps[:len(builtinCaps):len(builtinCaps)], cfg.Caps...),
+		available: make(map[string]string),
+		enabled:   make(map[string]bool),
+		batches:   make(map[string]*Msg),
+		capNew:    make(chan struct{}, 1),
+	}
+
+	if cfg.SASLUser != "" {
+		c.wantCaps = append(c.wantCaps, SASLCap)
 	}
 
 	readErrs := make(chan error)
@@ -69,15 +159,61 @@ func dial(conn net.Conn, nick, fullname, pass, bridgeNick string) (*Client, erro
 
 	go c.muxErrors(readErrs, writeErrs, errChan)
 
-	return c, c.register(nick, fullname, pass)
+	if err := c.register(cfg); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// close closes the connection and discards
+// any remaining messages and errors.
+func (c *Client) close() {
+	close(c.Out)
+	go func() {
+		for range c.In {
+		}
+	}()
+	go func() {
+		for range c.Errors {
+		}
+	}()
 }
 
-// register registers a name with the server
-func (c *Client) register(nick, fullname, pass string) error {
-	if pass != "" {
+// maxUnderscores is the number of underscores that
+// register will append to the last alternate nick.
+const maxUnderscores = 3
+
+// register negotiates capabilities, authenticates
+// with SASL if configured, and registers a name
+// with the server.
+// If the nick is in use, each of the alternate nicks
+// is tried in turn, followed by the last nick tried
+// with up to maxUnderscores underscores appended.
+func (c *Client) register(cfg Config) error {
+	nicks := append([]string{cfg.Nick}, cfg.AltNicks...)
+	nick := nicks[0]
+	nextNick := func() bool {
+		switch {
+		case len(nicks) > 1:
+			nicks = nicks[1:]
+			nick = nicks[0]
+		case len(nick) < len(nicks[0])+maxUnderscores:
+			nick += "_"
+		default:
+			return false
+		}
+		return true
+	}
+
+	// Servers that don't support capabilities
+	// ignore CAP or reply ERR_UNKNOWNCOMMAND,
+	// and register the client as usual.
+	c.Out <- Msg{Cmd: CAP, Args: []string{"LS", "302"}}
+	if cfg.Pass != "" {
 		c.Out <- Msg{
 			Cmd:  "PASS",
-			Args: []string{pass},
+			Args: []string{cfg.Pass},
 		}
 	}
 	c.Out <- Msg{
@@ -86,13 +222,52 @@ func (c *Client) register(nick, fullname, pass string) error {
 	}
 	c.Out <- Msg{
 		Cmd:  "USER",
-		Args: []string{nick, "0", "*", fullname},
+		Args: []string{nick, "0", "*", cfg.FullName},
 	}
+	authenticating := false
 	for msg := range c.In {
 		switch msg.Cmd {
+		case CAP:
+			switch sub, done := capSub(msg); {
+			case sub == "LS" && done:
+				if req, ok := c.capReq(); ok {
+					c.Out <- req
+				} else {
+					c.Out <- Msg{Cmd: CAP, Args: []string{"END"}}
+				}
+			case sub == "ACK" && cfg.SASLUser != "" && !authenticating && c.HasCap(SASLCap):
+				authenticating = true
+				c.Out <- Msg{Cmd: AUTHENTICATE, Args: []string{"PLAIN"}}
+			case sub == "ACK" || sub == "NAK":
+				c.Out <- Msg{Cmd: CAP, Args: []string{"END"}}
+			}
+
+		case AUTHENTICATE:
+			if len(msg.Args) > 0 && msg.Args[0] == "+" {
+				for _, m := range authenticatePlain(cfg.SASLUser, cfg.SASLPass) {
+					c.Out <- m
+				}
+			}
+
+		case RPL_SASLSUCCESS, ERR_SASLALREADY:
+			c.Authenticated = true
+			c.Out <- Msg{Cmd: CAP, Args: []string{"END"}}
+
+		case ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED, ERR_NICKLOCKED:
+			why := CmdNames[msg.Cmd]
+			if len(msg.Args) > 0 {
+				why = msg.Args[len(msg.Args)-1]
+			}
+			return errors.New("SASL authentication failed: " + why)
+
+		case ERR_NICKNAMEINUSE, ERR_NICKCOLLISION, ERR_UNAVAILRESOURCE:
+			if !nextNick() {
+				return errors.New("no available nick name")
+			}
+			c.Out <- Msg{Cmd: NICK, Args: []string{nick}}
+
 		case ERR_NONICKNAMEGIVEN, ERR_ERRONEUSNICKNAME,
-			ERR_NICKNAMEINUSE, ERR_NICKCOLLISION,
-			ERR_UNAVAILRESOURCE, ERR_RESTRICTED,
+			ERR_RESTRICTED,
 			ERR_NEEDMOREPARAMS, ERR_ALREADYREGISTRED:
 			if len(msg.Args) > 0 {
 				return errors.New(msg.Args[len(msg.Args)-1])
@@ -101,6 +276,10 @@ func (c *Client) register(nick, fullname, pass string) error {
 
 		case RPL_WELCOME:
 			c.Server = msg.Origin
+			c.Nick = nick
+			if len(msg.Args) > 0 {
+				c.Nick = msg.Args[0]
+			}
 			return nil
 
 		case PING:
@@ -120,6 +299,97 @@ func (c *Client) register(nick, fullname, pass string) error {
 	return errors.New("unexpected end of file")
 }
 
+// Ping sends a keepalive PING to the server.
+// The time at which it is sent is recorded so that
+// the round-trip time can be measured when the
+// server replies with the matching PONG.
+func (c *Client) Ping() {
+	now := time.Now()
+	tok := strconv.FormatInt(now.UnixNano(), 36)
+	c.mu.Lock()
+	// PINGs unanswered for longer than the read
+	// deadline are assumed lost and are forgotten.
+	for t, s := range c.pings {
+		if now.Sub(s) > deadline {
+			delete(c.pings, t)
+		}
+	}
+	c.pings[tok] = now
+	c.mu.Unlock()
+	c.Out <- Msg{Cmd: PING, Args: []string{tok}}
+}
+
+// Lag returns the current and average round-trip
+// time to the server, as measured by keepalive PINGs.
+// If a PING has been outstanding for longer than the
+// last measured lag, then its age is the current lag,
+// even before the first PONG is received. The average
+// is zero until the first PONG is received.
+func (c *Client) Lag() (cur, avg time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cur = c.lag
+	for _, t := range c.pings {
+		if d := time.Since(t); d > cur {
+			cur = d
+		}
+	}
+	return cur, c.avgLag
+}
+
+// ISupport returns the value of a token advertised
+// by the server in RPL_ISUPPORT, and whether the
+// server advertised it.
+func (c *Client) ISupport(token string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.isupport[strings.ToUpper(token)]
+	return v, ok
+}
+
+// isupported records the tokens in an RPL_ISUPPORT message.
+// The first argument is the nick and the last is a
+// human-readable message, neither is a token.
+func (c *Client) isupported(m Msg) {
+	if len(m.Args) < 3 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tok := range m.Args[1 : len(m.Args)-1] {
+		if strings.HasPrefix(tok, "-") {
+			delete(c.isupport, strings.ToUpper(tok[1:]))
+			continue
+		}
+		k, v := splitString(tok, '=')
+		c.isupport[strings.ToUpper(k)] = v
+	}
+}
+
+// pong records the round-trip time of the
+// keepalive PING with the given token.
+// PINGs sent before the matched one are
+// assumed lost and are forgotten.
+func (c *Client) pong(tok string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sent, ok := c.pings[tok]
+	if !ok {
+		return
+	}
+	for t, s := range c.pings {
+		if !s.After(sent) {
+			delete(c.pings, t)
+		}
+	}
+	c.lag = time.Since(sent)
+	if c.avgLag == 0 {
+		c.avgLag = c.lag
+	} else {
+		c.avgLag = (7*c.avgLag + c.lag) / 8
+	}
+}
+
 const deadline = 1 * time.Minute
 
 // readMsgs reads messages from the client and
@@ -137,33 +407,39 @@ func (c *Client) readMsgs(errs chan<- error, ms chan<- Msg) {
 				break
 			}
 		}
-		const nickClose = "> "
-		if c.bridgeNick != "" && m.Origin == c.bridgeNick && len(m.Args) > 1 &&
-			strings.HasPrefix(m.Args[1], "<") && strings.Contains(m.Args[1], nickClose) {
-			msg := m.Args[1]
-			closeNick := strings.Index(msg, nickClose)
-			nick := removeZeroWidthSpaces(msg[1:closeNick])
-			msg = removeZeroWidthSpaces(msg[closeNick+len(nickClose):])
-			m.Origin = nick + " (" + c.bridgeNick + ")"
-			m.Args[1] = msg
+		if m.Time.IsZero() {
+			m.Time = time.Now()
+		}
+		switch {
+		case m.Cmd == PONG && len(m.Args) > 0:
+			c.pong(m.Args[len(m.Args)-1])
+		case m.Cmd == RPL_ISUPPORT:
+			c.isupported(m)
+		case m.Cmd == CAP:
+			if c.capMsg(m) == "NEW" {
+				select {
+				case c.capNew <- struct{}{}:
+				default:
+				}
+			}
+		}
+		for _, b := range c.bridges {
+			if b.relay(&m) {
+				break
+			}
+		}
+		for _, b := range c.expireBatches(time.Now()) {
+			ms <- b
+		}
+		if m, ok := c.batch(m); ok {
+			ms <- m
 		}
-		ms <- m
 	}
 	close(errs)
 	close(ms)
 	c.conn.Close()
 }
 
-func removeZeroWidthSpaces(s string) string {
-	var b strings.Builder
-	for _, r := range s {
-		if r != 0x200b /* Zero-width space */ {
-			b.WriteRune(r)
-		}
-	}
-	return b.String()
-}
-
 // writeMsgs writes the messages coming in on the
 // channel to the connection.  If there is an error,
 // it is sent on the errs channel.  If the error occurs
@@ -172,7 +448,23 @@ func removeZeroWidthSpaces(s string) string {
 // discards all remaining messages.
 func (c *Client) writeMsgs(errs chan<- error, ms <-chan Msg) {
 	out := bufio.NewWriter(c.conn)
-	for m := range ms {
+	for {
+		var m Msg
+		select {
+		case msg, ok := <-ms:
+			if !ok {
+				close(errs)
+				c.conn.Close()
+				return
+			}
+			m = msg
+		case <-c.capNew:
+			req, ok := c.capReq()
+			if !ok {
+				continue
+			}
+			m = req
+		}
 		str, err := m.RawString()
 		if err != nil {
 			errs <- err
@@ -0,0 +1,65 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPong(t *testing.T) {
+	c := &Client{pings: make(map[string]time.Time)}
+	now := time.Now()
+	c.pings["a"] = now.Add(-3 * time.Second)
+	c.pings["b"] = now.Add(-2 * time.Second)
+	c.pings["c"] = now.Add(-1 * time.Second)
+
+	c.pong("unknown")
+	if cur, avg := c.Lag(); avg != 0 || cur < 3*time.Second {
+		t.Errorf("before pong got lag %v, avg %v", cur, avg)
+	}
+
+	c.pong("b")
+	if len(c.pings) != 1 {
+		t.Errorf("expected only the later ping outstanding, got %v", c.pings)
+	}
+	cur, avg := c.Lag()
+	if cur < 2*time.Second || avg != c.lag {
+		t.Errorf("after pong got lag %v, avg %v", cur, avg)
+	}
+}
+
+func TestISupported(t *testing.T) {
+	c := &Client{isupport: map[string]string{"OLD": ""}}
+	m, _ := ParseMsg(":srv 005 me MONITOR=100 excepts -OLD NETWORK=Test :are supported by this server")
+	c.isupported(m)
+	tests := []struct {
+		tok, val string
+		ok       bool
+	}{
+		{"MONITOR", "100", true},
+		{"monitor", "100", true},
+		{"EXCEPTS", "", true},
+		{"NETWORK", "Test", true},
+		{"OLD", "", false},
+		{"me", "", false},
+	}
+	for _, test := range tests {
+		if v, ok := c.ISupport(test.tok); v != test.val || ok != test.ok {
+			t.Errorf("ISupport(%q)=%q,%v, want %q,%v", test.tok, v, ok, test.val, test.ok)
+		}
+	}
+}
+
+func TestPingForgetsLost(t *testing.T) {
+	out := make(chan Msg, 1)
+	c := &Client{Out: out, pings: make(map[string]time.Time)}
+	c.pings["lost"] = time.Now().Add(-deadline - time.Second)
+	c.pings["late"] = time.Now().Add(-deadline / 2)
+	c.Ping()
+	m := <-out
+	if _, ok := c.pings["lost"]; ok || len(c.pings) != 2 {
+		t.Errorf("got outstanding pings %v, want late and the new ping", c.pings)
+	}
+	if _, ok := c.pings[m.Args[0]]; !ok {
+		t.Errorf("the new ping %v is not outstanding", m)
+	}
+}
@@ -0,0 +1,721 @@
+package irc
+
+import (
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes how to connect to
+// and register with an IRC server.
+type Config struct {
+	// Addr is the server's address, as host:port.
+	Addr string
+
+	// Nick is the nick name to register.
+	Nick string
+
+	// AltNicks are alternate nick names
+	// to register if Nick is in use.
+	AltNicks []string
+
+	// Regain is the NickServ command, REGAIN or GHOST,
+	// used to reclaim Nick, identifying with SASLPass,
+	// or with Pass if there is no SASLPass, when
+	// registered with an alternate nick name.
+	// If Regain is empty then NickServ is not used,
+	// but Nick is still reclaimed once it is free.
+	Regain string
+
+	// FullName is the user's full name.
+	FullName string
+
+	// Pass is the connection password, if any.
+	Pass string
+
+	// SASLUser and SASLPass are the credentials with
+	// which to authenticate using SASL PLAIN, if any.
+	SASLUser string
+	SASLPass string
+
+	// SSL is true if the connection uses SSL.
+	SSL bool
+
+	// TrustSSL is true if the server's SSL
+	// certificate should not be verified.
+	TrustSSL bool
+
+	// Bridges are the chat bridges whose
+	// relayed messages are rewritten.
+	Bridges []Bridge
+
+	// Caps are the IRCv3 capabilities to
+	// request if the server supports them.
+	Caps []string
+
+	// Autojoin are the channels that a Conn
+	// joins on every connection.
+	Autojoin []Channel
+}
+
+// Backoff is the policy used by a Conn
+// to decide when to redial the server.
+type Backoff struct {
+	// Initial is the delay before the first redial.
+	// The delay doubles with each consecutive failure.
+	Initial time.Duration
+
+	// Max is the maximum delay between redials.
+	Max time.Duration
+
+	// Jitter is the fraction, between 0 and 1,
+	// by which each delay is randomly varied.
+	Jitter float64
+
+	// MaxAttempts is the number of consecutive
+	// failures after which the Conn gives up.
+	// If MaxAttempts is zero it never gives up.
+	MaxAttempts int
+
+	// Stable is the amount of time that a connection
+	// must last before it is no longer considered
+	// to be a failure.
+	Stable time.Duration
+}
+
+// DefaultBackoff is a reasonable Backoff policy.
+var DefaultBackoff = Backoff{
+	Initial:     2 * time.Second,
+	Max:         5 * time.Minute,
+	Jitter:      0.2,
+	MaxAttempts: 10,
+	Stable:      1 * time.Minute,
+}
+
+// delay returns the amount of time to wait
+// before the given redial attempt, starting at 1.
+func (b Backoff) delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if b.Jitter > 0 {
+		d += time.Duration(b.Jitter * float64(d) * (2*rand.Float64() - 1))
+	}
+	return d
+}
+
+// An EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// Connected is sent when the Conn
+	// has connected and registered.
+	Connected EventKind = iota
+
+	// Disconnected is sent when the
+	// connection to the server is lost.
+	Disconnected
+
+	// Reconnecting is sent when the Conn
+	// is waiting to redial the server.
+	Reconnecting
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case Connected:
+		return "Connected"
+	case Disconnected:
+		return "Disconnected"
+	case Reconnecting:
+		return "Reconnecting"
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// An Event is a change in the state
+// of a Conn's connection to the server.
+type Event struct {
+	Kind EventKind
+
+	// Nick is the registered nick name.
+	// It is set for Connected events.
+	Nick string
+
+	// Authenticated is whether the connection
+	// authenticated with SASL. It is set for
+	// Connected events.
+	Authenticated bool
+
+	// Err is the error that caused a Disconnected
+	// event, or the error dialing the server that
+	// preceded a Reconnecting event.
+	Err error
+
+	// Attempt is the number of the coming redial,
+	// and Delay is the amount of time until it.
+	// They are set for Reconnecting events.
+	Attempt int
+	Delay   time.Duration
+}
+
+// A Conn is a connection to an IRC server
+// that redials the server when the connection
+// is lost, restoring the joined channels,
+// away status, and nick name.
+//
+// A Conn gives up when its Backoff policy
+// says so or when a QUIT message is sent
+// on Out, after which Events and In are closed.
+type Conn struct {
+	// In is a channel of all incoming messages
+	// from the server, across connections.
+	In <-chan Msg
+
+	// Msgs sent to Out are written to the server.
+	// Msgs sent while disconnected are discarded.
+	Out chan<- Msg
+
+	// Events is a channel of connection state changes.
+	// Connected is sent before any messages from
+	// the new connection are sent on In.
+	Events <-chan Event
+
+	// Errors is a channel of errors that
+	// did not cause a disconnection,
+	// such as MsgTooLong.
+	Errors <-chan error
+
+	cfg     Config
+	backoff Backoff
+	in      chan<- Msg
+	events  chan<- Event
+	errs    chan<- error
+
+	// quit is closed when a QUIT is sent.
+	quit     chan struct{}
+	quitOnce sync.Once
+
+	// redial is sent to by Reconnect to
+	// redial without waiting for the backoff.
+	redial chan struct{}
+
+	// mu protects the fields below.
+	mu sync.Mutex
+
+	// client is the current connection,
+	// or nil if disconnected.
+	client *Client
+
+	// nick is the current nick name, and primary
+	// is the nick name that we would like to have.
+	nick, primary string
+
+	// reclaims is the number of NICKs sent by reclaim
+	// whose replies have not yet been received.
+	reclaims int
+
+	// channels are the joined channels
+	// keyed by their lower-case name.
+	channels map[string]Channel
+
+	// keys are the keys sent with JOINs,
+	// keyed by lower-case channel name.
+	keys map[string]string
+
+	// away is the away message, or the
+	// empty string if not away.
+	away string
+}
+
+// Connect returns a Conn that connects to
+// the server described by the Config,
+// redialing according to the Backoff policy.
+func Connect(cfg Config, backoff Backoff) *Conn {
+	in := make(chan Msg)
+	out := make(chan Msg)
+	events := make(chan Event)
+	errs := make(chan error)
+	cfg.Autojoin = append([]Channel(nil), cfg.Autojoin...)
+	c := &Conn{
+		In:       in,
+		Out:      out,
+		Events:   events,
+		Errors:   errs,
+		cfg:      cfg,
+		backoff:  backoff,
+		in:       in,
+		events:   events,
+		errs:     errs,
+		quit:     make(chan struct{}),
+		redial:   make(chan struct{}, 1),
+		nick:     cfg.Nick,
+		primary:  cfg.Nick,
+		channels: make(map[string]Channel),
+		keys:     make(map[string]string),
+	}
+	go c.writeMsgs(out)
+	go c.run()
+	return c
+}
+
+// Ping sends a keepalive PING if connected.
+func (c *Conn) Ping() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil {
+		c.client.Ping()
+	}
+}
+
+// Reconnect closes the current connection, if any,
+// and redials the server without waiting for the
+// backoff delay. The session state is restored on
+// the new connection, as after any disconnection.
+func (c *Conn) Reconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case c.redial <- struct{}{}:
+	default:
+	}
+	if c.client != nil {
+		c.client.conn.Close()
+	}
+}
+
+// Lag returns the current and average lag
+// of the current connection, or zero if
+// disconnected.
+func (c *Conn) Lag() (cur, avg time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return 0, 0
+	}
+	return c.client.Lag()
+}
+
+// ISupport returns the value of an RPL_ISUPPORT token
+// advertised by the server of the current connection,
+// and whether it was advertised.
+func (c *Conn) ISupport(token string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return "", false
+	}
+	return c.client.ISupport(token)
+}
+
+// HasCap returns whether the capability is
+// enabled on the current connection.
+func (c *Conn) HasCap(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client != nil && c.client.HasCap(name)
+}
+
+// CapValue returns the value of a capability
+// advertised by the server of the current
+// connection, and whether it was advertised.
+func (c *Conn) CapValue(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		return "", false
+	}
+	return c.client.CapValue(name)
+}
+
+// Channels returns the joined channels, sorted by name.
+func (c *Conn) Channels() []Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var chs []Channel
+	for _, ch := range c.channels {
+		chs = append(chs, ch)
+	}
+	sort.Slice(chs, func(i, j int) bool {
+		return strings.ToLower(chs[i].Name) < strings.ToLower(chs[j].Name)
+	})
+	return chs
+}
+
+// Autojoin adds channels to those joined on every
+// connection, returning the channels that were not
+// already among them or whose keys have changed.
+func (c *Conn) Autojoin(chs ...Channel) []Channel {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var added []Channel
+	for _, ch := range chs {
+		switch i := indexChannel(c.cfg.Autojoin, ch.Name); {
+		case i < 0:
+			c.cfg.Autojoin = append(c.cfg.Autojoin, ch)
+		case c.cfg.Autojoin[i].Key != ch.Key:
+			c.cfg.Autojoin[i] = ch
+		default:
+			continue
+		}
+		added = append(added, ch)
+	}
+	return added
+}
+
+// indexChannel returns the index of the named
+// channel in chs, or -1 if it is not present.
+func indexChannel(chs []Channel, name string) int {
+	for i, ch := range chs {
+		if strings.EqualFold(ch.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Conn) quitting() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// run dials and serves connections until
+// quitting or the backoff policy gives up.
+func (c *Conn) run() {
+	defer func() {
+		close(c.events)
+		close(c.in)
+		close(c.errs)
+	}()
+	attempt := 0
+	for {
+		c.mu.Lock()
+		cfg := c.cfg
+		cfg.Nick = c.primary
+		c.mu.Unlock()
+
+		cl, dialErr := DialConfig(cfg)
+		if dialErr == nil && c.quitting() {
+			// The QUIT was sent during the dial,
+			// when there was no connection to send it on.
+			cl.Out <- Msg{Cmd: QUIT}
+			cl.close()
+			return
+		}
+		if dialErr == nil {
+			begin := time.Now()
+			err := c.serve(cl)
+			c.events <- Event{Kind: Disconnected, Err: err}
+			if time.Since(begin) >= c.backoff.Stable {
+				attempt = 0
+			}
+		}
+		if c.quitting() {
+			return
+		}
+		attempt++
+		if c.backoff.MaxAttempts > 0 && attempt > c.backoff.MaxAttempts {
+			return
+		}
+		d := c.backoff.delay(attempt)
+		c.events <- Event{Kind: Reconnecting, Err: dialErr, Attempt: attempt, Delay: d}
+		select {
+		case <-time.After(d):
+		case <-c.redial:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// serve relays the messages of a newly registered
+// Client until it is disconnected, returning the error
+// that disconnected it. The session state is restored
+// once the server has sent its message of the day,
+// by which time it has sent RPL_ISUPPORT.
+func (c *Conn) serve(cl *Client) error {
+	c.mu.Lock()
+	c.client = cl
+	c.nick = cl.Nick
+	c.reclaims = 0
+	select {
+	case <-c.redial:
+	default:
+	}
+	c.mu.Unlock()
+
+	c.events <- Event{Kind: Connected, Nick: cl.Nick, Authenticated: cl.Authenticated}
+	restored := false
+
+	regain := time.NewTicker(regainInterval)
+	defer regain.Stop()
+	if m, ok := c.regainMsg(); ok {
+		cl.Out <- m
+	}
+
+	var err error
+	in, errs := cl.In, cl.Errors
+	for in != nil {
+		select {
+		case m, ok := <-in:
+			if !ok {
+				in = nil
+				break
+			}
+			if c.track(m) {
+				if m.Batch != nil {
+					c.trackBatch(m.Batch)
+				}
+				c.in <- m
+			}
+			if !restored && (m.Cmd == RPL_ENDOFMOTD || m.Cmd == ERR_NOMOTD) {
+				restored = true
+				c.mu.Lock()
+				restore := c.restoreMsgs()
+				c.mu.Unlock()
+				for _, m := range restore {
+					cl.Out <- m
+				}
+			}
+
+		case <-regain.C:
+			c.reclaim()
+
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				break
+			}
+			if _, ok := e.(MsgTooLong); ok {
+				c.errs <- e
+			} else if err == nil {
+				err = e
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.client = nil
+	close(cl.Out)
+	c.mu.Unlock()
+
+	if errs != nil {
+		for e := range errs {
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	return err
+}
+
+// regainInterval is the amount of time between
+// attempts to reclaim the primary nick name.
+const regainInterval = 30 * time.Second
+
+// regainMsg returns the NickServ message that regains
+// the primary nick name, and true if it should be sent.
+func (c *Conn) regainMsg() (Msg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pass := c.cfg.SASLPass
+	if pass == "" {
+		pass = c.cfg.Pass
+	}
+	if c.cfg.Regain == "" || pass == "" || c.nick == c.primary {
+		return Msg{}, false
+	}
+	cmd := strings.ToUpper(c.cfg.Regain) + " " + c.primary + " " + pass
+	return Msg{Cmd: PRIVMSG, Args: []string{"NickServ", cmd}}, true
+}
+
+// reclaim tries to change to the primary nick
+// name if it is not the current nick name.
+func (c *Conn) reclaim() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil && c.nick != c.primary {
+		c.reclaims++
+		c.client.Out <- Msg{Cmd: NICK, Args: []string{c.primary}}
+	}
+}
+
+// restoreMsgs returns the messages that join the
+// autojoin channels and restore the joined channels
+// and away status.
+// It must be called with c.mu held.
+func (c *Conn) restoreMsgs() []Msg {
+	var chs []Channel
+	for _, ch := range c.cfg.Autojoin {
+		if indexChannel(chs, ch.Name) < 0 {
+			chs = append(chs, ch)
+		}
+	}
+	var names []string
+	for n := range c.channels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if indexChannel(chs, n) < 0 {
+			chs = append(chs, c.channels[n])
+		}
+	}
+	for _, ch := range chs {
+		if ch.Key != "" {
+			c.keys[strings.ToLower(ch.Name)] = ch.Key
+		}
+	}
+	v, _ := c.client.ISupport("TARGMAX")
+	ms := joinMsgs(chs, targMax(v, JOIN))
+	if c.away != "" {
+		ms = append(ms, Msg{Cmd: AWAY, Args: []string{c.away}})
+	}
+	return ms
+}
+
+// writeMsgs writes messages to the
+// current connection, tracking the state
+// to restore on the next connection.
+func (c *Conn) writeMsgs(out <-chan Msg) {
+	for m := range out {
+		c.mu.Lock()
+		c.trackOut(m)
+		if c.client != nil {
+			c.client.Out <- m
+		}
+		c.mu.Unlock()
+		if m.Cmd == QUIT {
+			c.quitOnce.Do(func() { close(c.quit) })
+		}
+	}
+}
+
+// track updates the session state
+// from a message sent by the server.
+// It returns false if the message is an error reply
+// to an attempt to reclaim the primary nick name
+// and should not be passed on to the user.
+func (c *Conn) track(m Msg) bool {
+	c.mu.Lock()
+	self := strings.EqualFold(m.Origin, c.nick)
+	primary := c.primary
+	reclaiming := c.nick != c.primary
+	if c.reclaims > 0 && isNickErr(m) && len(m.Args) > 1 && strings.EqualFold(m.Args[1], primary) {
+		c.reclaims--
+		if m.Cmd == ERR_ERRONEUSNICKNAME {
+			// The server will never accept it.
+			c.primary = c.nick
+		}
+		c.mu.Unlock()
+		return false
+	}
+	c.mu.Unlock()
+
+	switch {
+	case reclaiming && strings.EqualFold(m.Origin, primary) &&
+		(m.Cmd == QUIT || m.Cmd == NICK):
+		// The primary nick is now free.
+		defer c.reclaim()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case m.Cmd == JOIN && self && len(m.Args) > 0:
+		n := strings.ToLower(m.Args[0])
+		c.channels[n] = Channel{Name: m.Args[0], Key: c.keys[n]}
+
+	case m.Cmd == PART && self && len(m.Args) > 0:
+		for _, ch := range strings.Split(m.Args[0], ",") {
+			delete(c.channels, strings.ToLower(ch))
+		}
+
+	case m.Cmd == KICK && len(m.Args) > 1 && strings.EqualFold(m.Args[1], c.nick):
+		delete(c.channels, strings.ToLower(m.Args[0]))
+
+	case m.Cmd == NICK && self && len(m.Args) > 0:
+		if c.reclaims > 0 && strings.EqualFold(m.Args[0], c.primary) {
+			c.reclaims--
+		}
+		c.nick = m.Args[0]
+		c.primary = m.Args[0]
+	}
+	return true
+}
+
+// isNickErr returns whether the message is
+// an error reply to a NICK.
+func isNickErr(m Msg) bool {
+	switch m.Cmd {
+	case ERR_NICKNAMEINUSE, ERR_ERRONEUSNICKNAME, ERR_NICKCOLLISION, ERR_UNAVAILRESOURCE:
+		return true
+	}
+	return false
+}
+
+// trackBatch updates the session state from the messages
+// of a batch, such as the QUITs of a netsplit, and from
+// those of the batches nested in it. History is not
+// tracked, since it does not describe the current state.
+func (c *Conn) trackBatch(b *Batch) {
+	if b.Type == "chathistory" {
+		return
+	}
+	for _, m := range b.Msgs {
+		if m.Batch != nil {
+			c.trackBatch(m.Batch)
+		} else {
+			c.track(m)
+		}
+	}
+}
+
+// trackOut updates the session state from
+// a message sent to the server.
+// It must be called with c.mu held.
+func (c *Conn) trackOut(m Msg) {
+	switch {
+	case m.Cmd == JOIN && len(m.Args) > 0:
+		if m.Args[0] == "0" {
+			c.channels = make(map[string]Channel)
+			break
+		}
+		var keys []string
+		if len(m.Args) > 1 {
+			keys = strings.Split(m.Args[1], ",")
+		}
+		for i, ch := range strings.Split(m.Args[0], ",") {
+			if i < len(keys) {
+				c.keys[strings.ToLower(ch)] = keys[i]
+			}
+		}
+
+	case m.Cmd == AWAY:
+		c.away = ""
+		if len(m.Args) > 0 {
+			c.away = m.Args[0]
+		}
+
+	case m.Cmd == NICK && c.client == nil && len(m.Args) > 0:
+		// The nick is changed when the server
+		// confirms it, or else on the next dial.
+		c.nick = m.Args[0]
+		c.primary = m.Args[0]
+	}
+}
@@ -0,0 +1,367 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+	for _, test := range tests {
+		if d := b.delay(test.attempt); d != test.want {
+			t.Errorf("delay(%d)=%v, want %v", test.attempt, d, test.want)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.delay(4); d < 4*time.Second || d > 12*time.Second {
+			t.Errorf("delay(4) with jitter=%v, want within [4s, 12s]", d)
+		}
+	}
+}
+
+// A fakeServer accepts connections and
+// registers each client with a welcome.
+type fakeServer struct {
+	net.Listener
+	t *testing.T
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeServer{Listener: l, t: t}
+}
+
+// accept accepts a connection and reads
+// lines until the client has sent USER
+// and a NICK accepted by the nick function,
+// then replies with RPL_WELCOME and ERR_NOMOTD.
+func (s *fakeServer) accept(nick func(string) (string, bool)) (net.Conn, *bufio.Reader) {
+	c, err := s.Accept()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	r := bufio.NewReader(c)
+	var n string
+	var nickOK, user bool
+	for !nickOK || !user {
+		l := s.readLine(r)
+		switch {
+		case strings.HasPrefix(l, "NICK "):
+			if n, nickOK = nick(strings.TrimPrefix(l[len("NICK "):], ":")); !nickOK {
+				c.Write([]byte(":srv 433 * " + n + " :Nickname is already in use\r\n"))
+			}
+		case strings.HasPrefix(l, "USER "):
+			user = true
+		}
+	}
+	c.Write([]byte(":srv 001 " + n + " :Welcome\r\n:srv 422 " + n + " :MOTD File is missing\r\n"))
+	return c, r
+}
+
+func (s *fakeServer) readLine(r *bufio.Reader) string {
+	l, err := r.ReadString('\n')
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return strings.TrimRight(l, "\r\n")
+}
+
+func anyNick(n string) (string, bool) { return n, true }
+
+func TestConnRestore(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := Connect(Config{Addr: s.Addr().String(), Nick: "me", Autojoin: []Channel{{Name: "#z"}}},
+		Backoff{Initial: time.Millisecond, Max: time.Millisecond})
+	go func() {
+		for range c.In {
+		}
+	}()
+
+	sc, r := s.accept(anyNick)
+	if ev := <-c.Events; ev.Kind != Connected || ev.Nick != "me" {
+		t.Fatalf("got event %+v, want Connected as me", ev)
+	}
+	if l := s.readLine(r); l != "JOIN :#z" {
+		t.Fatalf("got %q, want autojoin JOIN", l)
+	}
+	c.Out <- Msg{Cmd: JOIN, Args: []string{"#a,#b", "key"}}
+	if l := s.readLine(r); l != "JOIN #a,#b :key" {
+		t.Fatalf("got %q, want JOIN", l)
+	}
+	c.Out <- Msg{Cmd: AWAY, Args: []string{"lunch"}}
+	s.readLine(r)
+	sc.Write([]byte(":me!u@h JOIN #a\r\n:me!u@h JOIN #b\r\n:me!u@h NICK you\r\n"))
+	sc.Write([]byte(":you!u@h JOIN #c\r\n:op!u@h KICK #c you\r\n"))
+	sc.Close()
+
+	if ev := <-c.Events; ev.Kind != Disconnected {
+		t.Fatalf("got event %+v, want Disconnected", ev)
+	}
+	if ev := <-c.Events; ev.Kind != Reconnecting || ev.Attempt != 1 {
+		t.Fatalf("got event %+v, want Reconnecting attempt 1", ev)
+	}
+
+	var nick string
+	sc, r = s.accept(func(n string) (string, bool) {
+		nick = n
+		return n, true
+	})
+	defer sc.Close()
+	if ev := <-c.Events; ev.Kind != Connected || ev.Nick != "you" {
+		t.Fatalf("got event %+v, want Connected as you", ev)
+	}
+	if nick != "you" {
+		t.Errorf("reconnected as %q, want you", nick)
+	}
+	for _, want := range []string{"JOIN #a,#z,#b :key", "AWAY :lunch"} {
+		if l := s.readLine(r); l != want {
+			t.Errorf("got %q, want %q", l, want)
+		}
+	}
+
+	c.Out <- Msg{Cmd: QUIT}
+	s.readLine(r)
+	sc.Close()
+	for range c.Events {
+	}
+}
+
+func TestConnAltNicks(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := Connect(Config{
+		Addr:     s.Addr().String(),
+		Nick:     "me",
+		AltNicks: []string{"alt"},
+		Regain:   "regain",
+		Pass:     "secret",
+	}, Backoff{Initial: time.Millisecond, Max: time.Millisecond})
+	in := make(chan Msg, 10)
+	go func() {
+		for m := range c.In {
+			in <- m
+		}
+	}()
+
+	var tried []string
+	sc, r := s.accept(func(n string) (string, bool) {
+		tried = append(tried, n)
+		return n, n == "alt_"
+	})
+	defer sc.Close()
+	if ev := <-c.Events; ev.Kind != Connected || ev.Nick != "alt_" {
+		t.Fatalf("got event %+v, want Connected as alt_", ev)
+	}
+	if strings.Join(tried, " ") != "me alt alt_" {
+		t.Errorf("tried nicks %v, want [me alt alt_]", tried)
+	}
+	if l := s.readLine(r); l != "PRIVMSG NickServ :REGAIN me secret" {
+		t.Errorf("got %q, want NickServ REGAIN", l)
+	}
+	if m := <-in; m.Cmd != ERR_NOMOTD {
+		t.Errorf("got %v, want ERR_NOMOTD", m)
+	}
+
+	sc.Write([]byte(":me!u@h QUIT :ping timeout\r\n"))
+	if l := s.readLine(r); l != "NICK :me" {
+		t.Errorf("got %q, want NICK me", l)
+	}
+	sc.Write([]byte(":srv 433 alt_ me :Nickname is already in use\r\n:alt_!u@h NICK me\r\n"))
+	if m := <-in; m.Cmd != QUIT {
+		t.Errorf("got %v, want QUIT", m)
+	}
+	if m := <-in; m.Cmd != NICK {
+		t.Errorf("got %v, want NICK; ERR_NICKNAMEINUSE should be dropped", m)
+	}
+
+	c.Out <- Msg{Cmd: QUIT}
+	s.readLine(r)
+	sc.Close()
+	for range c.Events {
+	}
+}
+
+func TestConnQuitWhileDialing(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := Connect(Config{Addr: s.Addr().String(), Nick: "me"},
+		Backoff{Initial: time.Millisecond, Max: time.Millisecond})
+	go func() {
+		for range c.In {
+		}
+	}()
+
+	sc, err := s.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	r := bufio.NewReader(sc)
+	s.readLine(r) // CAP LS
+	s.readLine(r) // NICK
+	s.readLine(r) // USER
+	c.Out <- Msg{Cmd: QUIT}
+	// The QUIT has been handled once the next message is received.
+	c.Out <- Msg{Cmd: PING, Args: []string{"x"}}
+	sc.Write([]byte(":srv 001 me :Welcome\r\n"))
+	if l := s.readLine(r); l != "QUIT" {
+		t.Errorf("got %q, want QUIT", l)
+	}
+	for ev := range c.Events {
+		t.Errorf("got event %+v, want none", ev)
+	}
+}
+
+func TestConnNickInUse(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := Connect(Config{Addr: s.Addr().String(), Nick: "me"},
+		Backoff{Initial: time.Millisecond, Max: time.Millisecond})
+	in := make(chan Msg, 10)
+	go func() {
+		for m := range c.In {
+			in <- m
+		}
+	}()
+
+	sc, r := s.accept(anyNick)
+	defer sc.Close()
+	<-c.Events
+	c.Out <- Msg{Cmd: NICK, Args: []string{"taken"}}
+	s.readLine(r)
+	sc.Write([]byte(":srv 433 me taken :Nickname is already in use\r\n"))
+	for got := false; !got; {
+		select {
+		case m := <-in:
+			got = m.Cmd == ERR_NICKNAMEINUSE
+		case <-time.After(time.Second):
+			t.Fatal("ERR_NICKNAMEINUSE was not passed on")
+		}
+	}
+	c.reclaim()
+	c.Out <- Msg{Cmd: PING, Args: []string{"x"}}
+	if l := s.readLine(r); l != "PING :x" {
+		t.Errorf("got %q, want PING; the refused nick should not be reclaimed", l)
+	}
+
+	c.Out <- Msg{Cmd: QUIT}
+	s.readLine(r)
+	sc.Close()
+	for range c.Events {
+	}
+}
+
+func TestConnReconnect(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.Close()
+
+	c := Connect(Config{Addr: s.Addr().String(), Nick: "me"},
+		Backoff{Initial: time.Hour, Max: time.Hour})
+	go func() {
+		for range c.In {
+		}
+	}()
+
+	sc, _ := s.accept(anyNick)
+	defer sc.Close()
+	if ev := <-c.Events; ev.Kind != Connected {
+		t.Fatalf("got event %+v, want Connected", ev)
+	}
+	c.Reconnect()
+	if ev := <-c.Events; ev.Kind != Disconnected {
+		t.Fatalf("got event %+v, want Disconnected", ev)
+	}
+	if ev := <-c.Events; ev.Kind != Reconnecting {
+		t.Fatalf("got event %+v, want Reconnecting", ev)
+	}
+
+	sc, r := s.accept(anyNick)
+	defer sc.Close()
+	if ev := <-c.Events; ev.Kind != Connected {
+		t.Fatalf("got event %+v, want Connected without waiting for the backoff", ev)
+	}
+
+	c.Out <- Msg{Cmd: QUIT}
+	s.readLine(r)
+	sc.Close()
+	for range c.Events {
+	}
+}
+
+func TestRegainMsg(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Regain: "regain", Pass: "server"}, "REGAIN me server"},
+		{Config{Regain: "ghost", SASLPass: "sasl"}, "GHOST me sasl"},
+		{Config{Regain: "regain", Pass: "server", SASLPass: "sasl"}, "REGAIN me sasl"},
+		{Config{Regain: "regain"}, ""},
+		{Config{Pass: "server"}, ""},
+	}
+	for _, test := range tests {
+		c := &Conn{cfg: test.cfg, nick: "alt", primary: "me"}
+		m, ok := c.regainMsg()
+		switch {
+		case ok != (test.want != ""):
+			t.Errorf("regainMsg() with %+v sent=%v, want %v", test.cfg, ok, !ok)
+		case ok && (m.Args[0] != "NickServ" || m.Args[1] != test.want):
+			t.Errorf("regainMsg() with %+v=%v, want NickServ %s", test.cfg, m, test.want)
+		}
+	}
+}
+
+func TestTrackBatch(t *testing.T) {
+	c := &Conn{
+		nick:     "me",
+		primary:  "me",
+		channels: map[string]Channel{"#a": {Name: "#a"}, "#b": {Name: "#b"}},
+		keys:     map[string]string{},
+	}
+	parse := func(l string) Msg {
+		m, err := ParseMsg(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	c.trackBatch(&Batch{Type: "labeled-response", Msgs: []Msg{
+		parse(":me!u@h PART #a"),
+		parse(":me!u@h JOIN #c"),
+	}})
+	c.trackBatch(&Batch{Type: "chathistory", Msgs: []Msg{
+		parse(":me!u@h JOIN #old"),
+		parse(":me!u@h PART #b"),
+	}})
+	var got []string
+	for _, ch := range c.Channels() {
+		got = append(got, ch.Name)
+	}
+	if strings.Join(got, " ") != "#b #c" {
+		t.Errorf("got channels %v, want [#b #c]", got)
+	}
+}
@@ -0,0 +1,33 @@
+package irc
+
+// The IRCv3 draft/chathistory extension.
+
+import (
+	"strconv"
+	"time"
+)
+
+// HistoryCap is the capability that enables CHATHISTORY.
+// Replies to CHATHISTORY are sent in a chathistory Batch
+// whose first parameter is the target.
+const HistoryCap = "draft/chathistory"
+
+// HistoryLatest returns a CHATHISTORY message requesting
+// the latest n messages sent to the target.
+func HistoryLatest(target string, n int) Msg {
+	return historyMsg("LATEST", target, "*", n)
+}
+
+// HistoryAfter returns a CHATHISTORY message requesting
+// the n messages sent to the target just after time t.
+func HistoryAfter(target string, t time.Time, n int) Msg {
+	return historyMsg("AFTER", target, historyTime(t), n)
+}
+
+func historyMsg(sub, target, ref string, n int) Msg {
+	return Msg{Cmd: CHATHISTORY, Args: []string{sub, target, ref, strconv.Itoa(n)}}
+}
+
+func historyTime(t time.Time) string {
+	return "timestamp=" + t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,103 @@
+package irc
+
+// Joining several channels at once.
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A Channel is a channel name and its key,
+// which is empty if the channel has none.
+type Channel struct {
+	Name, Key string
+}
+
+// ParseChannels parses a comma-separated list of
+// channels, each optionally followed by a colon
+// and its key, such as "#go,#secret:key".
+func ParseChannels(s string) []Channel {
+	var chs []Channel
+	for _, c := range strings.Split(s, ",") {
+		name, key, _ := strings.Cut(strings.TrimSpace(c), ":")
+		if name != "" {
+			chs = append(chs, Channel{Name: name, Key: key})
+		}
+	}
+	return chs
+}
+
+// String returns the channel in the form
+// read by ParseChannels.
+func (ch Channel) String() string {
+	if ch.Key == "" {
+		return ch.Name
+	}
+	return ch.Name + ":" + ch.Key
+}
+
+// targMax returns the maximum number of targets
+// of the command given the value of the TARGMAX
+// RPL_ISUPPORT token, or zero if there is no limit.
+func targMax(isupport, cmd string) int {
+	for _, t := range strings.Split(isupport, ",") {
+		c, n := splitString(t, ':')
+		if strings.EqualFold(c, cmd) {
+			max, _ := strconv.Atoi(n)
+			return max
+		}
+	}
+	return 0
+}
+
+// joinMsgs returns the JOIN messages that join
+// the channels, as few as allowed by the maximum
+// number of targets per message, zero meaning
+// no limit, and the maximum message length.
+// Channels with keys are joined first, since
+// the keys of a JOIN belong to its first channels.
+func joinMsgs(chs []Channel, max int) []Msg {
+	var keyed, unkeyed []Channel
+	for _, ch := range chs {
+		if ch.Key != "" {
+			keyed = append(keyed, ch)
+		} else {
+			unkeyed = append(unkeyed, ch)
+		}
+	}
+
+	// The length of the message, less its arguments,
+	// with the prefix added when relayed by the server.
+	const overhead = len(MsgMarker) + prefixReserve + len("JOIN  :")
+
+	var ms []Msg
+	var names, keys []string
+	n := 0
+	flush := func() {
+		if len(names) == 0 {
+			return
+		}
+		m := Msg{Cmd: JOIN, Args: []string{strings.Join(names, ",")}}
+		if len(keys) > 0 {
+			m.Args = append(m.Args, strings.Join(keys, ","))
+		}
+		ms = append(ms, m)
+		names, keys, n = nil, nil, 0
+	}
+	for _, ch := range append(keyed, unkeyed...) {
+		l := len(ch.Name) + 1
+		if ch.Key != "" {
+			l += len(ch.Key) + 1
+		}
+		if max > 0 && len(names) == max || overhead+n+l > MaxMsgLength {
+			flush()
+		}
+		names = append(names, ch.Name)
+		if ch.Key != "" {
+			keys = append(keys, ch.Key)
+		}
+		n += l
+	}
+	flush()
+	return ms
+}
@@ -0,0 +1,61 @@
+package irc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseChannels(t *testing.T) {
+	got := ParseChannels("#a, #b:key,,#c")
+	want := []Channel{{"#a", ""}, {"#b", "key"}, {"#c", ""}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTargMax(t *testing.T) {
+	const v = "NAMES:1,LIST:1,KICK:1,WHOIS:1,PRIVMSG:4,NOTICE:4,JOIN:,ACCEPT:"
+	if n := targMax(v, "PRIVMSG"); n != 4 {
+		t.Errorf("targMax(PRIVMSG)=%d, want 4", n)
+	}
+	if n := targMax(v, "join"); n != 0 {
+		t.Errorf("targMax(JOIN)=%d, want 0", n)
+	}
+	if n := targMax(v, "MONITOR"); n != 0 {
+		t.Errorf("targMax(MONITOR)=%d, want 0", n)
+	}
+}
+
+func TestJoinMsgs(t *testing.T) {
+	chs := []Channel{{"#a", ""}, {"#b", "kb"}, {"#c", ""}, {"#d", "kd"}}
+	var got []string
+	for _, m := range joinMsgs(chs, 3) {
+		got = append(got, strings.Join(m.Args, " "))
+	}
+	want := []string{"#b,#d,#a kb,kd", "#c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	chs = nil
+	for i := 0; i < 100; i++ {
+		chs = append(chs, Channel{Name: fmt.Sprintf("#channel%02d", i)})
+	}
+	ms := joinMsgs(chs, 0)
+	if len(ms) < 2 {
+		t.Errorf("got %d messages, want several", len(ms))
+	}
+	n := 0
+	for _, m := range ms {
+		m.Origin = strings.Repeat("x", prefixReserve-2)
+		if _, err := m.RawString(); err != nil {
+			t.Errorf("message too long: %v", err)
+		}
+		n += len(strings.Split(m.Args[0], ","))
+	}
+	if n != len(chs) {
+		t.Errorf("joined %d channels, want %d", n, len(chs))
+	}
+}
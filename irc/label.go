@@ -0,0 +1,30 @@
+package irc
+
+// The IRCv3 echo-message and labeled-response extensions.
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	// EchoCap is the capability with which the server
+	// echoes PRIVMSGs and NOTICEs back to their sender.
+	EchoCap = "echo-message"
+
+	// LabelCap is the capability with which the server
+	// tags its response to a message with the message's
+	// label tag. A response of several messages is sent
+	// as a labeled-response Batch opened by a BATCH
+	// message with the label tag, and a message with
+	// no other response is acknowledged with an ACK.
+	LabelCap = "labeled-response"
+)
+
+var labelSeq uint64
+
+// NewLabel returns a new label, unique
+// within the process, for the label tag.
+func NewLabel() string {
+	return "v" + strconv.FormatUint(atomic.AddUint64(&labelSeq, 1), 36)
+}
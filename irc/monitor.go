@@ -0,0 +1,51 @@
+package irc
+
+import "strings"
+
+// MonitorMsgs returns the MONITOR + messages that add
+// the nicks to the monitor list, as many to each message
+// as fit within MaxMsgLength.
+func MonitorMsgs(nicks []string) []Msg {
+	const overhead = len(MsgMarker) + len("MONITOR + ")
+
+	var ms []Msg
+	for _, batch := range splitNicks(nicks, overhead) {
+		ms = append(ms, Msg{Cmd: MONITOR, Args: []string{"+", strings.Join(batch, ",")}})
+	}
+	return ms
+}
+
+// IsOnMsgs returns the ISON messages that ask
+// whether the nicks are online, as many to each
+// message as fit within MaxMsgLength.
+func IsOnMsgs(nicks []string) []Msg {
+	const overhead = len(MsgMarker) + len("ISON :")
+
+	var ms []Msg
+	for _, batch := range splitNicks(nicks, overhead) {
+		ms = append(ms, Msg{Cmd: ISON, Args: batch})
+	}
+	return ms
+}
+
+// splitNicks splits the nicks into batches that fit
+// within MaxMsgLength after overhead bytes, each nick
+// taking its length and a separator.
+func splitNicks(nicks []string, overhead int) [][]string {
+	var batches [][]string
+	var batch []string
+	n := 0
+	for _, nick := range nicks {
+		l := len(nick) + 1
+		if len(batch) > 0 && overhead+n+l > MaxMsgLength {
+			batches = append(batches, batch)
+			batch, n = nil, 0
+		}
+		batch = append(batch, nick)
+		n += l
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
@@ -0,0 +1,63 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMonitorMsgs(t *testing.T) {
+	var nicks []string
+	for i := 0; i < 100; i++ {
+		nicks = append(nicks, fmt.Sprintf("nickname%02d", i))
+	}
+	ms := MonitorMsgs(nicks)
+	if len(ms) < 2 {
+		t.Fatalf("got %d messages, want the nicks split over several", len(ms))
+	}
+	var got []string
+	for _, m := range ms {
+		raw, err := m.RawString()
+		if err != nil {
+			t.Fatalf("%v: %v", m, err)
+		}
+		if len(raw)+len(MsgMarker) > MaxMsgLength {
+			t.Errorf("message of %d bytes, want at most %d", len(raw)+len(MsgMarker), MaxMsgLength)
+		}
+		if m.Args[0] != "+" {
+			t.Errorf("got %v, want MONITOR +", m)
+		}
+		got = append(got, strings.Split(m.Args[1], ",")...)
+	}
+	if strings.Join(got, " ") != strings.Join(nicks, " ") {
+		t.Errorf("got nicks %v, want %v", got, nicks)
+	}
+	if ms := MonitorMsgs(nil); len(ms) != 0 {
+		t.Errorf("MonitorMsgs(nil)=%v, want none", ms)
+	}
+}
+
+func TestIsOnMsgs(t *testing.T) {
+	var nicks []string
+	for i := 0; i < 100; i++ {
+		nicks = append(nicks, fmt.Sprintf("nickname%02d", i))
+	}
+	ms := IsOnMsgs(nicks)
+	if len(ms) < 2 {
+		t.Fatalf("got %d messages, want the nicks split over several", len(ms))
+	}
+	var got []string
+	for _, m := range ms {
+		raw, err := m.RawString()
+		if err != nil {
+			t.Fatalf("%v: %v", m, err)
+		}
+		if len(raw)+len(MsgMarker) > MaxMsgLength {
+			t.Errorf("message of %d bytes, want at most %d", len(raw)+len(MsgMarker), MaxMsgLength)
+		}
+		got = append(got, m.Args...)
+	}
+	if strings.Join(got, " ") != strings.Join(nicks, " ") {
+		t.Errorf("got nicks %v, want %v", got, nicks)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"time"
 )
 
 // A Msg is the basic unit of communication
@@ -41,6 +43,27 @@ type Msg struct {
 
 	// Args is the argument list.
 	Args []string
+
+	// Tags are the IRCv3 message tags,
+	// mapping tag keys to their unescaped values.
+	Tags map[string]string
+
+	// Time is the time at which the message was sent.
+	// For messages read from the server it is the
+	// IRCv3 server-time if the message has one,
+	// and otherwise the time the message was read.
+	Time time.Time
+
+	// Batch is the complete batch opened by
+	// a BATCH message, or nil if the message
+	// doesn't open a collected batch.
+	Batch *Batch
+
+	// Relay is the nick of the Bridge that relayed
+	// the message, in which case Origin is the nick
+	// of the relayed sender, or the empty string if
+	// the message was not relayed.
+	Relay string
 }
 
 // RawString returns the raw string representation
@@ -49,12 +72,22 @@ type Msg struct {
 // the fields of the message.  If there is an error
 // generating the raw string then the string is
 // invalid and an error is returned.
+//
+// The length limit does not include the tags.
 func (m Msg) RawString() (string, error) {
 	raw := ""
+	tags := ""
 	if m.Raw != "" {
 		raw = m.Raw
+		if raw[0] == '@' {
+			tags, raw = splitString(raw, ' ')
+			tags += " "
+		}
 		goto out
 	}
+	if len(m.Tags) > 0 {
+		tags = "@" + FormatTags(m.Tags) + " "
+	}
 	if m.Origin != "" {
 		raw += ":" + m.Origin
 		if m.User != "" {
@@ -74,7 +107,7 @@ out:
 	if len(raw) > MaxMsgLength-len(MsgMarker) {
 		return "", MsgTooLong{raw, len(raw) - (MaxMsgLength - len(MsgMarker))}
 	}
-	return strings.TrimRight(raw, "\n"), nil
+	return tags + strings.TrimRight(raw, "\n"), nil
 }
 
 // ParseMsg parses a message from
@@ -84,7 +117,16 @@ func ParseMsg(data string) (Msg, error) {
 	var msg Msg
 	msg.Raw = data
 
-	if data[0] == ':' {
+	if data[0] == '@' {
+		var tags string
+		tags, data = splitString(data[1:], ' ')
+		msg.Tags = ParseTags(tags)
+		if t, err := time.Parse(time.RFC3339, msg.Tags["time"]); err == nil {
+			msg.Time = t
+		}
+	}
+
+	if len(data) > 0 && data[0] == ':' {
 		var prefix string
 		prefix, data = splitString(data[1:], ' ')
 		msg.Origin, prefix = splitString(prefix, '!')
@@ -105,6 +147,82 @@ func ParseMsg(data string) (Msg, error) {
 	return msg, nil
 }
 
+// ParseTags returns the tags of an IRCv3 tag string,
+// without its leading '@'.
+func ParseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, t := range strings.Split(s, ";") {
+		if t == "" {
+			continue
+		}
+		k, v := splitString(t, '=')
+		tags[k] = unescapeTag(v)
+	}
+	return tags
+}
+
+// FormatTags returns the IRCv3 tag string,
+// without a leading '@', of the tags.
+// The tags are sorted by key.
+func FormatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(k)
+		if v := tags[k]; v != "" {
+			b.WriteByte('=')
+			b.WriteString(tagEscaper.Replace(v))
+		}
+	}
+	return b.String()
+}
+
+var tagEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	";", "\\:",
+	" ", "\\s",
+	"\r", "\\r",
+	"\n", "\\n",
+)
+
+// unescapeTag returns the unescaped
+// value of an IRCv3 tag.
+func unescapeTag(v string) string {
+	if strings.IndexByte(v, '\\') < 0 {
+		return v
+	}
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			b.WriteByte(v[i])
+			continue
+		}
+		if i++; i == len(v) {
+			break
+		}
+		switch v[i] {
+		case ':':
+			b.WriteByte(';')
+		case 's':
+			b.WriteByte(' ')
+		case 'r':
+			b.WriteByte('\r')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(v[i])
+		}
+	}
+	return b.String()
+}
+
 // readMsg returns the next message from
 // the stream.  If error is non-nil then the message
 // is not valid.
@@ -148,9 +266,14 @@ func splitString(s string, delim rune) (string, string) {
 }
 
 // MaxMsgLength is the maximum length
-// of a message in bytes.
+// of a message in bytes, not including tags.
 const MaxMsgLength = 512
 
+// MaxTagsLength is the maximum length in bytes
+// of a message's tags, including the leading '@'
+// and the trailing space.
+const MaxTagsLength = 8191
+
 // MsgMarker is the marker delineating messages
 // in the TCP stream.
 const MsgMarker = "\r\n"
@@ -172,6 +295,7 @@ func (m MsgTooLong) Error() string {
 // returned string will be empty.
 func readMsgData(in *bufio.Reader) (string, error) {
 	var msg []byte
+	max := MaxMsgLength
 	for {
 		switch c, err := in.ReadByte(); {
 		case err == io.EOF && len(msg) > 0:
@@ -202,11 +326,14 @@ func readMsgData(in *bufio.Reader) (string, error) {
 			}
 			return string(msg), nil
 
-		case len(msg) >= MaxMsgLength-2:
+		case len(msg) >= max-2:
 			n, _ := junk(in)
 			return "", MsgTooLong{Msg: string(msg[:len(msg)-1]), NTrunc: n + 1}
 
 		default:
+			if len(msg) == 0 && c == '@' {
+				max += MaxTagsLength
+			}
 			msg = append(msg, c)
 		}
 	}
@@ -426,189 +553,240 @@ const (
 	ERR_USERSDONTMATCH    = "502"
 )
 
+// Cmd names of common extensions not in RFC 2812.
+const (
+	ACCOUNT          = "ACCOUNT"
+	ACK              = "ACK"
+	AUTHENTICATE     = "AUTHENTICATE"
+	BATCH            = "BATCH"
+	CAP              = "CAP"
+	CHATHISTORY      = "CHATHISTORY"
+	MONITOR          = "MONITOR"
+	REDACT           = "REDACT"
+	TAGMSG           = "TAGMSG"
+	RPL_ISUPPORT     = "005" // replaces RPL_BOUNCE in practice
+	RPL_MONONLINE    = "730"
+	RPL_MONOFFLINE   = "731"
+	RPL_MONLIST      = "732"
+	RPL_ENDOFMONLIST = "733"
+	ERR_MONLISTFULL  = "734"
+	RPL_LOGGEDIN     = "900"
+	RPL_LOGGEDOUT    = "901"
+	ERR_NICKLOCKED   = "902"
+	RPL_SASLSUCCESS  = "903"
+	ERR_SASLFAIL     = "904"
+	ERR_SASLTOOLONG  = "905"
+	ERR_SASLABORTED  = "906"
+	ERR_SASLALREADY  = "907"
+	RPL_SASLMECHS    = "908"
+)
+
 // CmdNames is a map from command strings to their names.
 var CmdNames = map[string]string{
-	PASS:     "PASS",
-	NICK:     "NICK",
-	USER:     "USER",
-	OPER:     "OPER",
-	MODE:     "MODE",
-	SERVICE:  "SERVICE",
-	QUIT:     "QUIT",
-	SQUIT:    "SQUIT",
-	JOIN:     "JOIN",
-	PART:     "PART",
-	TOPIC:    "TOPIC",
-	NAMES:    "NAMES",
-	LIST:     "LIST",
-	INVITE:   "INVITE",
-	KICK:     "KICK",
-	PRIVMSG:  "PRIVMSG",
-	NOTICE:   "NOTICE",
-	MOTD:     "MOTD",
-	LUSERS:   "LUSERS",
-	VERSION:  "VERSION",
-	STATS:    "STATS",
-	LINKS:    "LINKS",
-	TIME:     "TIME",
-	CONNECT:  "CONNECT",
-	TRACE:    "TRACE",
-	ADMIN:    "ADMIN",
-	INFO:     "INFO",
-	SERVLIST: "SERVLIST",
-	SQUERY:   "SQUERY",
-	WHO:      "WHO",
-	WHOIS:    "WHOIS",
-	WHOWAS:   "WHOWAS",
-	KILL:     "KILL",
-	PING:     "PING",
-	PONG:     "PONG",
-	ERROR:    "ERROR",
-	AWAY:     "AWAY",
-	REHASH:   "REHASH",
-	DIE:      "DIE",
-	RESTART:  "RESTART",
-	SUMMON:   "SUMMON",
-	USERS:    "USERS",
-	WALLOPS:  "WALLOPS",
-	USERHOST: "USERHOST",
-	ISON:     "ISON",
-	"001":    "RPL_WELCOME",
-	"002":    "RPL_YOURHOST",
-	"003":    "RPL_CREATED",
-	"004":    "RPL_MYINFO",
-	"005":    "RPL_BOUNCE",
-	"302":    "RPL_USERHOST",
-	"303":    "RPL_ISON",
-	"301":    "RPL_AWAY",
-	"305":    "RPL_UNAWAY",
-	"306":    "RPL_NOWAWAY",
-	"311":    "RPL_WHOISUSER",
-	"312":    "RPL_WHOISSERVER",
-	"313":    "RPL_WHOISOPERATOR",
-	"317":    "RPL_WHOISIDLE",
-	"318":    "RPL_ENDOFWHOIS",
-	"319":    "RPL_WHOISCHANNELS",
-	"314":    "RPL_WHOWASUSER",
-	"369":    "RPL_ENDOFWHOWAS",
-	"321":    "RPL_LISTSTART",
-	"322":    "RPL_LIST",
-	"323":    "RPL_LISTEND",
-	"325":    "RPL_UNIQOPIS",
-	"324":    "RPL_CHANNELMODEIS",
-	"331":    "RPL_NOTOPIC",
-	"332":    "RPL_TOPIC",
-	"333":    "RPL_TOPICWHOTIME", // ircu specific (not in the RFC)
-	"341":    "RPL_INVITING",
-	"342":    "RPL_SUMMONING",
-	"346":    "RPL_INVITELIST",
-	"347":    "RPL_ENDOFINVITELIST",
-	"348":    "RPL_EXCEPTLIST",
-	"349":    "RPL_ENDOFEXCEPTLIST",
-	"351":    "RPL_VERSION",
-	"352":    "RPL_WHOREPLY",
-	"315":    "RPL_ENDOFWHO",
-	"353":    "RPL_NAMREPLY",
-	"366":    "RPL_ENDOFNAMES",
-	"364":    "RPL_LINKS",
-	"365":    "RPL_ENDOFLINKS",
-	"367":    "RPL_BANLIST",
-	"368":    "RPL_ENDOFBANLIST",
-	"371":    "RPL_INFO",
-	"374":    "RPL_ENDOFINFO",
-	"375":    "RPL_MOTDSTART",
-	"372":    "RPL_MOTD",
-	"376":    "RPL_ENDOFMOTD",
-	"381":    "RPL_YOUREOPER",
-	"382":    "RPL_REHASHING",
-	"383":    "RPL_YOURESERVICE",
-	"391":    "RPL_TIME",
-	"392":    "RPL_USERSSTART",
-	"393":    "RPL_USERS",
-	"394":    "RPL_ENDOFUSERS",
-	"395":    "RPL_NOUSERS",
-	"200":    "RPL_TRACELINK",
-	"201":    "RPL_TRACECONNECTING",
-	"202":    "RPL_TRACEHANDSHAKE",
-	"203":    "RPL_TRACEUNKNOWN",
-	"204":    "RPL_TRACEOPERATOR",
-	"205":    "RPL_TRACEUSER",
-	"206":    "RPL_TRACESERVER",
-	"207":    "RPL_TRACESERVICE",
-	"208":    "RPL_TRACENEWTYPE",
-	"209":    "RPL_TRACECLASS",
-	"210":    "RPL_TRACERECONNECT",
-	"261":    "RPL_TRACELOG",
-	"262":    "RPL_TRACEEND",
-	"211":    "RPL_STATSLINKINFO",
-	"212":    "RPL_STATSCOMMANDS",
-	"219":    "RPL_ENDOFSTATS",
-	"242":    "RPL_STATSUPTIME",
-	"243":    "RPL_STATSOLINE",
-	"221":    "RPL_UMODEIS",
-	"234":    "RPL_SERVLIST",
-	"235":    "RPL_SERVLISTEND",
-	"251":    "RPL_LUSERCLIENT",
-	"252":    "RPL_LUSEROP",
-	"253":    "RPL_LUSERUNKNOWN",
-	"254":    "RPL_LUSERCHANNELS",
-	"255":    "RPL_LUSERME",
-	"256":    "RPL_ADMINME",
-	"257":    "RPL_ADMINLOC",
-	"258":    "RPL_ADMINLOC",
-	"259":    "RPL_ADMINEMAIL",
-	"263":    "RPL_TRYAGAIN",
-	"401":    "ERR_NOSUCHNICK",
-	"402":    "ERR_NOSUCHSERVER",
-	"403":    "ERR_NOSUCHCHANNEL",
-	"404":    "ERR_CANNOTSENDTOCHAN",
-	"405":    "ERR_TOOMANYCHANNELS",
-	"406":    "ERR_WASNOSUCHNICK",
-	"407":    "ERR_TOOMANYTARGETS",
-	"408":    "ERR_NOSUCHSERVICE",
-	"409":    "ERR_NOORIGIN",
-	"411":    "ERR_NORECIPIENT",
-	"412":    "ERR_NOTEXTTOSEND",
-	"413":    "ERR_NOTOPLEVEL",
-	"414":    "ERR_WILDTOPLEVEL",
-	"415":    "ERR_BADMASK",
-	"421":    "ERR_UNKNOWNCOMMAND",
-	"422":    "ERR_NOMOTD",
-	"423":    "ERR_NOADMININFO",
-	"424":    "ERR_FILEERROR",
-	"431":    "ERR_NONICKNAMEGIVEN",
-	"432":    "ERR_ERRONEUSNICKNAME",
-	"433":    "ERR_NICKNAMEINUSE",
-	"436":    "ERR_NICKCOLLISION",
-	"437":    "ERR_UNAVAILRESOURCE",
-	"441":    "ERR_USERNOTINCHANNEL",
-	"442":    "ERR_NOTONCHANNEL",
-	"443":    "ERR_USERONCHANNEL",
-	"444":    "ERR_NOLOGIN",
-	"445":    "ERR_SUMMONDISABLED",
-	"446":    "ERR_USERSDISABLED",
-	"451":    "ERR_NOTREGISTERED",
-	"461":    "ERR_NEEDMOREPARAMS",
-	"462":    "ERR_ALREADYREGISTRED",
-	"463":    "ERR_NOPERMFORHOST",
-	"464":    "ERR_PASSWDMISMATCH",
-	"465":    "ERR_YOUREBANNEDCREEP",
-	"466":    "ERR_YOUWILLBEBANNED",
-	"467":    "ERR_KEYSET",
-	"471":    "ERR_CHANNELISFULL",
-	"472":    "ERR_UNKNOWNMODE",
-	"473":    "ERR_INVITEONLYCHAN",
-	"474":    "ERR_BANNEDFROMCHAN",
-	"475":    "ERR_BADCHANNELKEY",
-	"476":    "ERR_BADCHANMASK",
-	"477":    "ERR_NOCHANMODES",
-	"478":    "ERR_BANLISTFULL",
-	"481":    "ERR_NOPRIVILEGES",
-	"482":    "ERR_CHANOPRIVSNEEDED",
-	"483":    "ERR_CANTKILLSERVER",
-	"484":    "ERR_RESTRICTED",
-	"485":    "ERR_UNIQOPPRIVSNEEDED",
-	"491":    "ERR_NOOPERHOST",
-	"501":    "ERR_UMODEUNKNOWNFLAG",
-	"502":    "ERR_USERSDONTMATCH",
+	PASS:         "PASS",
+	NICK:         "NICK",
+	USER:         "USER",
+	OPER:         "OPER",
+	MODE:         "MODE",
+	SERVICE:      "SERVICE",
+	QUIT:         "QUIT",
+	SQUIT:        "SQUIT",
+	JOIN:         "JOIN",
+	PART:         "PART",
+	TOPIC:        "TOPIC",
+	NAMES:        "NAMES",
+	LIST:         "LIST",
+	INVITE:       "INVITE",
+	KICK:         "KICK",
+	PRIVMSG:      "PRIVMSG",
+	NOTICE:       "NOTICE",
+	MOTD:         "MOTD",
+	LUSERS:       "LUSERS",
+	VERSION:      "VERSION",
+	STATS:        "STATS",
+	LINKS:        "LINKS",
+	TIME:         "TIME",
+	CONNECT:      "CONNECT",
+	TRACE:        "TRACE",
+	ADMIN:        "ADMIN",
+	INFO:         "INFO",
+	SERVLIST:     "SERVLIST",
+	SQUERY:       "SQUERY",
+	WHO:          "WHO",
+	WHOIS:        "WHOIS",
+	WHOWAS:       "WHOWAS",
+	KILL:         "KILL",
+	PING:         "PING",
+	PONG:         "PONG",
+	ERROR:        "ERROR",
+	AWAY:         "AWAY",
+	REHASH:       "REHASH",
+	DIE:          "DIE",
+	RESTART:      "RESTART",
+	SUMMON:       "SUMMON",
+	USERS:        "USERS",
+	WALLOPS:      "WALLOPS",
+	USERHOST:     "USERHOST",
+	ISON:         "ISON",
+	"001":        "RPL_WELCOME",
+	"002":        "RPL_YOURHOST",
+	"003":        "RPL_CREATED",
+	"004":        "RPL_MYINFO",
+	"005":        "RPL_BOUNCE",
+	"302":        "RPL_USERHOST",
+	"303":        "RPL_ISON",
+	"301":        "RPL_AWAY",
+	"305":        "RPL_UNAWAY",
+	"306":        "RPL_NOWAWAY",
+	"311":        "RPL_WHOISUSER",
+	"312":        "RPL_WHOISSERVER",
+	"313":        "RPL_WHOISOPERATOR",
+	"317":        "RPL_WHOISIDLE",
+	"318":        "RPL_ENDOFWHOIS",
+	"319":        "RPL_WHOISCHANNELS",
+	"314":        "RPL_WHOWASUSER",
+	"369":        "RPL_ENDOFWHOWAS",
+	"321":        "RPL_LISTSTART",
+	"322":        "RPL_LIST",
+	"323":        "RPL_LISTEND",
+	"325":        "RPL_UNIQOPIS",
+	"324":        "RPL_CHANNELMODEIS",
+	"331":        "RPL_NOTOPIC",
+	"332":        "RPL_TOPIC",
+	"333":        "RPL_TOPICWHOTIME", // ircu specific (not in the RFC)
+	"341":        "RPL_INVITING",
+	"342":        "RPL_SUMMONING",
+	"346":        "RPL_INVITELIST",
+	"347":        "RPL_ENDOFINVITELIST",
+	"348":        "RPL_EXCEPTLIST",
+	"349":        "RPL_ENDOFEXCEPTLIST",
+	"351":        "RPL_VERSION",
+	"352":        "RPL_WHOREPLY",
+	"315":        "RPL_ENDOFWHO",
+	"353":        "RPL_NAMREPLY",
+	"366":        "RPL_ENDOFNAMES",
+	"364":        "RPL_LINKS",
+	"365":        "RPL_ENDOFLINKS",
+	"367":        "RPL_BANLIST",
+	"368":        "RPL_ENDOFBANLIST",
+	"371":        "RPL_INFO",
+	"374":        "RPL_ENDOFINFO",
+	"375":        "RPL_MOTDSTART",
+	"372":        "RPL_MOTD",
+	"376":        "RPL_ENDOFMOTD",
+	"381":        "RPL_YOUREOPER",
+	"382":        "RPL_REHASHING",
+	"383":        "RPL_YOURESERVICE",
+	"391":        "RPL_TIME",
+	"392":        "RPL_USERSSTART",
+	"393":        "RPL_USERS",
+	"394":        "RPL_ENDOFUSERS",
+	"395":        "RPL_NOUSERS",
+	"200":        "RPL_TRACELINK",
+	"201":        "RPL_TRACECONNECTING",
+	"202":        "RPL_TRACEHANDSHAKE",
+	"203":        "RPL_TRACEUNKNOWN",
+	"204":        "RPL_TRACEOPERATOR",
+	"205":        "RPL_TRACEUSER",
+	"206":        "RPL_TRACESERVER",
+	"207":        "RPL_TRACESERVICE",
+	"208":        "RPL_TRACENEWTYPE",
+	"209":        "RPL_TRACECLASS",
+	"210":        "RPL_TRACERECONNECT",
+	"261":        "RPL_TRACELOG",
+	"262":        "RPL_TRACEEND",
+	"211":        "RPL_STATSLINKINFO",
+	"212":        "RPL_STATSCOMMANDS",
+	"219":        "RPL_ENDOFSTATS",
+	"242":        "RPL_STATSUPTIME",
+	"243":        "RPL_STATSOLINE",
+	"221":        "RPL_UMODEIS",
+	"234":        "RPL_SERVLIST",
+	"235":        "RPL_SERVLISTEND",
+	"251":        "RPL_LUSERCLIENT",
+	"252":        "RPL_LUSEROP",
+	"253":        "RPL_LUSERUNKNOWN",
+	"254":        "RPL_LUSERCHANNELS",
+	"255":        "RPL_LUSERME",
+	"256":        "RPL_ADMINME",
+	"257":        "RPL_ADMINLOC",
+	"258":        "RPL_ADMINLOC",
+	"259":        "RPL_ADMINEMAIL",
+	"263":        "RPL_TRYAGAIN",
+	"401":        "ERR_NOSUCHNICK",
+	"402":        "ERR_NOSUCHSERVER",
+	"403":        "ERR_NOSUCHCHANNEL",
+	"404":        "ERR_CANNOTSENDTOCHAN",
+	"405":        "ERR_TOOMANYCHANNELS",
+	"406":        "ERR_WASNOSUCHNICK",
+	"407":        "ERR_TOOMANYTARGETS",
+	"408":        "ERR_NOSUCHSERVICE",
+	"409":        "ERR_NOORIGIN",
+	"411":        "ERR_NORECIPIENT",
+	"412":        "ERR_NOTEXTTOSEND",
+	"413":        "ERR_NOTOPLEVEL",
+	"414":        "ERR_WILDTOPLEVEL",
+	"415":        "ERR_BADMASK",
+	"421":        "ERR_UNKNOWNCOMMAND",
+	"422":        "ERR_NOMOTD",
+	"423":        "ERR_NOADMININFO",
+	"424":        "ERR_FILEERROR",
+	"431":        "ERR_NONICKNAMEGIVEN",
+	"432":        "ERR_ERRONEUSNICKNAME",
+	"433":        "ERR_NICKNAMEINUSE",
+	"436":        "ERR_NICKCOLLISION",
+	"437":        "ERR_UNAVAILRESOURCE",
+	"441":        "ERR_USERNOTINCHANNEL",
+	"442":        "ERR_NOTONCHANNEL",
+	"443":        "ERR_USERONCHANNEL",
+	"444":        "ERR_NOLOGIN",
+	"445":        "ERR_SUMMONDISABLED",
+	"446":        "ERR_USERSDISABLED",
+	"451":        "ERR_NOTREGISTERED",
+	"461":        "ERR_NEEDMOREPARAMS",
+	"462":        "ERR_ALREADYREGISTRED",
+	"463":        "ERR_NOPERMFORHOST",
+	"464":        "ERR_PASSWDMISMATCH",
+	"465":        "ERR_YOUREBANNEDCREEP",
+	"466":        "ERR_YOUWILLBEBANNED",
+	"467":        "ERR_KEYSET",
+	"471":        "ERR_CHANNELISFULL",
+	"472":        "ERR_UNKNOWNMODE",
+	"473":        "ERR_INVITEONLYCHAN",
+	"474":        "ERR_BANNEDFROMCHAN",
+	"475":        "ERR_BADCHANNELKEY",
+	"476":        "ERR_BADCHANMASK",
+	"477":        "ERR_NOCHANMODES",
+	"478":        "ERR_BANLISTFULL",
+	"481":        "ERR_NOPRIVILEGES",
+	"482":        "ERR_CHANOPRIVSNEEDED",
+	"483":        "ERR_CANTKILLSERVER",
+	"484":        "ERR_RESTRICTED",
+	"485":        "ERR_UNIQOPPRIVSNEEDED",
+	"491":        "ERR_NOOPERHOST",
+	"501":        "ERR_UMODEUNKNOWNFLAG",
+	"502":        "ERR_USERSDONTMATCH",
+	ACCOUNT:      "ACCOUNT",
+	ACK:          "ACK",
+	AUTHENTICATE: "AUTHENTICATE",
+	BATCH:        "BATCH",
+	CAP:          "CAP",
+	CHATHISTORY:  "CHATHISTORY",
+	MONITOR:      "MONITOR",
+	REDACT:       "REDACT",
+	TAGMSG:       "TAGMSG",
+	"730":        "RPL_MONONLINE",
+	"731":        "RPL_MONOFFLINE",
+	"732":        "RPL_MONLIST",
+	"733":        "RPL_ENDOFMONLIST",
+	"734":        "ERR_MONLISTFULL",
+	"900":        "RPL_LOGGEDIN",
+	"901":        "RPL_LOGGEDOUT",
+	"902":        "ERR_NICKLOCKED",
+	"903":        "RPL_SASLSUCCESS",
+	"904":        "ERR_SASLFAIL",
+	"905":        "ERR_SASLTOOLONG",
+	"906":        "ERR_SASLABORTED",
+	"907":        "ERR_SASLALREADY",
+	"908":        "RPL_SASLMECHS",
 }
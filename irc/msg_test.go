@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestReadMsgOK(t *testing.T) {
@@ -55,7 +56,7 @@ func TestReadMsgOK(t *testing.T) {
 	for _, test := range tests {
 		m, err := ParseMsg(test.Raw)
 		if err != nil {
-			t.Errorf(err.Error())
+			t.Error(err)
 		}
 		if !reflect.DeepEqual(m, test) {
 			t.Errorf("failed to correctly parse %#v\nGot: %#v", test, m)
@@ -97,7 +98,7 @@ func TestReadMsgDataOk(t *testing.T) {
 				t.Errorf("expected end of messages")
 			}
 			if err != nil {
-				t.Errorf(err.Error())
+				t.Error(err)
 			}
 			if m != test.ms[i] {
 				t.Errorf("expected message %s, got %s",
@@ -136,3 +137,56 @@ func TestReadMsgDataError(t *testing.T) {
 		}
 	}
 }
+
+func TestParseMsgTags(t *testing.T) {
+	m, err := ParseMsg(`@time=2011-10-19T16:40:51.620Z;msgid=abc;+draft/reply=x\sy\:z\;flag :e!foo@bar.com PRIVMSG #chan :hi`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{
+		"time":         "2011-10-19T16:40:51.620Z",
+		"msgid":        "abc",
+		"+draft/reply": "x y;z",
+		"flag":         "",
+	}
+	if !reflect.DeepEqual(m.Tags, tags) {
+		t.Errorf("got tags %#v, want %#v", m.Tags, tags)
+	}
+	if m.Origin != "e" || m.Cmd != "PRIVMSG" || !reflect.DeepEqual(m.Args, []string{"#chan", "hi"}) {
+		t.Errorf("failed to parse message after tags: %#v", m)
+	}
+	if want := time.Date(2011, 10, 19, 16, 40, 51, 620000000, time.UTC); !m.Time.Equal(want) {
+		t.Errorf("got time %v, want %v", m.Time, want)
+	}
+}
+
+func TestRawStringTags(t *testing.T) {
+	m := Msg{
+		Cmd:  "TAGMSG",
+		Args: []string{"#chan"},
+		Tags: map[string]string{"+typing": "active", "label": "a b;c"},
+	}
+	raw, err := m.RawString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `@+typing=active;label=a\sb\:c TAGMSG :#chan`; raw != want {
+		t.Errorf("got %q, want %q", raw, want)
+	}
+	p, _ := ParseMsg(raw)
+	if !reflect.DeepEqual(p.Tags, m.Tags) {
+		t.Errorf("round trip got tags %#v, want %#v", p.Tags, m.Tags)
+	}
+}
+
+func TestReadMsgDataTags(t *testing.T) {
+	long := "@tag=" + strings.Repeat("x", 1000) + " PRIVMSG #chan :hi"
+	in := bufio.NewReader(strings.NewReader(long + "\r\n"))
+	m, err := readMsgData(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != long {
+		t.Errorf("got %q, want %q", m, long)
+	}
+}
@@ -0,0 +1,123 @@
+package irc
+
+// The IRCv3 draft/multiline extension.
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MultilineCap is the capability with which
+	// multi-line messages are sent as a Batch
+	// of type MultilineCap.
+	MultilineCap = "draft/multiline"
+
+	// ConcatTag tags a message in a multi-line batch
+	// that continues the previous line, rather than
+	// beginning a new one.
+	ConcatTag = "draft/multiline-concat"
+)
+
+// prefixReserve is the number of bytes reserved
+// for the prefix that the server adds when relaying
+// a message, :nick!user@host.
+const prefixReserve = 100
+
+// SplitText splits text into pieces short enough to be
+// the last argument of a message with the command and
+// target when relayed by the server. Text is only
+// split on UTF-8 character boundaries.
+func SplitText(cmd, target, text string) []string {
+	max := MaxMsgLength - len(MsgMarker) - prefixReserve - len(cmd+" "+target+" :")
+	var ps []string
+	for len(text) > max {
+		i := max
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+		ps = append(ps, text[:i])
+		text = text[i:]
+	}
+	return append(ps, text)
+}
+
+// MultilineLimits returns the maximum number of bytes
+// and lines in a multi-line batch given the value of the
+// draft/multiline capability. Zero means no limit.
+func MultilineLimits(capValue string) (maxBytes, maxLines int) {
+	for _, kv := range strings.Split(capValue, ",") {
+		k, v := splitString(kv, '=')
+		n, _ := strconv.Atoi(v)
+		switch k {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	return maxBytes, maxLines
+}
+
+// MultilineBatches returns the messages of the multi-line
+// batches that send the lines of text to the target as
+// PRIVMSGs, as few batches as allowed by the limits.
+// Each batch begins with its opening BATCH message
+// and ends with its closing BATCH message.
+// Lines too long for a single message are split,
+// and their continuations are tagged with ConcatTag.
+func MultilineBatches(target string, lines []string, maxBytes, maxLines int) [][]Msg {
+	var batches [][]Msg
+	var batch []Msg
+	var ref string
+	nbytes := 0
+	flush := func() {
+		if len(batch) > 1 {
+			batch = append(batch, Msg{Cmd: BATCH, Args: []string{"-" + ref}})
+			batches = append(batches, batch)
+		}
+		ref = NewLabel()
+		batch = []Msg{{Cmd: BATCH, Args: []string{"+" + ref, MultilineCap, target}}}
+		nbytes = 0
+	}
+	flush()
+	for _, l := range lines {
+		ps := SplitText(PRIVMSG, target, l)
+		n := len(l) + 1
+		if maxLines > 0 && len(batch)-1+len(ps) > maxLines ||
+			maxBytes > 0 && nbytes+n > maxBytes {
+			flush()
+		}
+		for i, p := range ps {
+			m := Msg{
+				Cmd:  PRIVMSG,
+				Args: []string{target, p},
+				Tags: map[string]string{"batch": ref},
+			}
+			if i > 0 {
+				m.Tags[ConcatTag] = ""
+			}
+			batch = append(batch, m)
+		}
+		nbytes += n
+	}
+	flush()
+	return batches
+}
+
+// MultilineText returns the text of a multi-line batch,
+// with its lines separated by newlines.
+func MultilineText(b *Batch) string {
+	var s strings.Builder
+	for i, m := range b.Msgs {
+		if len(m.Args) < 2 {
+			continue
+		}
+		if _, ok := m.Tags[ConcatTag]; i > 0 && !ok {
+			s.WriteByte('\n')
+		}
+		s.WriteString(m.Args[len(m.Args)-1])
+	}
+	return s.String()
+}
@@ -0,0 +1,69 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+	text := strings.Repeat("ö", 400)
+	ps := SplitText(PRIVMSG, "#chan", text)
+	if len(ps) < 2 {
+		t.Fatalf("got %d pieces, want at least 2", len(ps))
+	}
+	if strings.Join(ps, "") != text {
+		t.Errorf("pieces do not join to the text")
+	}
+	for _, p := range ps {
+		if !utf8.ValidString(p) {
+			t.Errorf("piece %q is not valid UTF-8", p)
+		}
+		m := Msg{Origin: strings.Repeat("x", prefixReserve-2), Cmd: PRIVMSG, Args: []string{"#chan", p}}
+		if _, err := m.RawString(); err != nil {
+			t.Errorf("piece is too long: %v", err)
+		}
+	}
+}
+
+func TestMultilineLimits(t *testing.T) {
+	b, l := MultilineLimits("max-bytes=4096,max-lines=24")
+	if b != 4096 || l != 24 {
+		t.Errorf("got %d, %d, want 4096, 24", b, l)
+	}
+	if b, l := MultilineLimits(""); b != 0 || l != 0 {
+		t.Errorf("got %d, %d, want 0, 0", b, l)
+	}
+}
+
+func TestMultilineBatches(t *testing.T) {
+	long := strings.Repeat("x", 500)
+	lines := []string{"a", "b", long, "c"}
+	batches := MultilineBatches("#chan", lines, 0, 3)
+	if len(batches) != 2 {
+		t.Fatalf("got %d batches, want 2", len(batches))
+	}
+	for _, b := range batches {
+		open, close := b[0], b[len(b)-1]
+		if open.Cmd != BATCH || open.Args[1] != MultilineCap || open.Args[2] != "#chan" {
+			t.Errorf("got opening %v, want BATCH +ref %s #chan", open, MultilineCap)
+		}
+		if close.Cmd != BATCH || close.Args[0] != "-"+open.Args[0][1:] {
+			t.Errorf("got closing %v, want BATCH -ref", close)
+		}
+		for _, m := range b[1 : len(b)-1] {
+			if m.Tags["batch"] != open.Args[0][1:] {
+				t.Errorf("got batch tag %q, want %q", m.Tags["batch"], open.Args[0][1:])
+			}
+		}
+	}
+
+	var got []string
+	for _, b := range batches {
+		got = append(got, MultilineText(&Batch{Msgs: b[1 : len(b)-1]}))
+	}
+	want := []string{"a\nb", long + "\nc"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got texts %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package irc
+
+// The IRCv3 +draft/reply and +draft/react client tags,
+// and the draft/message-redaction extension.
+
+const (
+	// IDTag is the tag with the server's
+	// unique identifier for a message.
+	IDTag = "msgid"
+
+	// ReplyTag tags a message with the
+	// msgid of the message it replies to.
+	ReplyTag = "+draft/reply"
+
+	// ReactTag tags a TAGMSG with a reaction
+	// to the message named by its ReplyTag.
+	ReactTag = "+draft/react"
+
+	// RedactCap is the capability that enables REDACT,
+	// with which a message is withdrawn by its msgid.
+	RedactCap = "draft/message-redaction"
+)
+
+// Reply returns a PRIVMSG sending text to the target
+// in reply to the message with the msgid.
+func Reply(target, msgid, text string) Msg {
+	return Msg{
+		Cmd:  PRIVMSG,
+		Args: []string{target, text},
+		Tags: map[string]string{ReplyTag: msgid},
+	}
+}
+
+// React returns a TAGMSG sending a reaction to
+// the message to the target with the msgid.
+func React(target, msgid, reaction string) Msg {
+	return Msg{
+		Cmd:  TAGMSG,
+		Args: []string{target},
+		Tags: map[string]string{ReplyTag: msgid, ReactTag: reaction},
+	}
+}
+
+// Redact returns a REDACT message withdrawing the
+// message to the target with the msgid.
+func Redact(target, msgid, reason string) Msg {
+	args := []string{target, msgid}
+	if reason != "" {
+		args = append(args, reason)
+	}
+	return Msg{Cmd: REDACT, Args: args}
+}
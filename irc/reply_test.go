@@ -0,0 +1,36 @@
+package irc
+
+import "testing"
+
+func TestClientTagMsgs(t *testing.T) {
+	tests := []struct {
+		msg  Msg
+		want string
+	}{
+		{Reply("#c", "abc", "hi there"), "@+draft/reply=abc PRIVMSG #c :hi there"},
+		{React("#c", "abc", "👍"), "@+draft/react=👍;+draft/reply=abc TAGMSG :#c"},
+		{Redact("#c", "abc", ""), "REDACT #c :abc"},
+		{Redact("#c", "abc", "oops"), "REDACT #c abc :oops"},
+		{Typing("bob", TypingActive), "@+typing=active TAGMSG :bob"},
+	}
+	for _, test := range tests {
+		got, err := test.msg.RawString()
+		if err != nil {
+			t.Errorf("%v.RawString() failed: %v", test.msg, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+		m, err := ParseMsg(got)
+		if err != nil {
+			t.Errorf("ParseMsg(%q) failed: %v", got, err)
+			continue
+		}
+		for k, v := range test.msg.Tags {
+			if m.Tags[k] != v {
+				t.Errorf("ParseMsg(%q) tag %s=%q, want %q", got, k, m.Tags[k], v)
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package irc
+
+// SASL authentication during registration.
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// SASLCap is the capability with which
+// the client authenticates using SASL.
+const SASLCap = "sasl"
+
+// maxAuthenticate is the maximum length of
+// the argument of an AUTHENTICATE message.
+const maxAuthenticate = 400
+
+// saslPlain returns whether the value of the sasl
+// capability allows the PLAIN mechanism.
+// An empty value lists no mechanisms.
+func saslPlain(capValue string) bool {
+	if capValue == "" {
+		return true
+	}
+	for _, m := range strings.Split(capValue, ",") {
+		if strings.EqualFold(m, "PLAIN") {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticatePlain returns the AUTHENTICATE
+// messages sending the PLAIN credentials.
+func authenticatePlain(user, pass string) []Msg {
+	s := base64.StdEncoding.EncodeToString([]byte(user + "\x00" + user + "\x00" + pass))
+	var ms []Msg
+	for len(s) >= maxAuthenticate {
+		ms = append(ms, Msg{Cmd: AUTHENTICATE, Args: []string{s[:maxAuthenticate]}})
+		s = s[maxAuthenticate:]
+	}
+	if s == "" {
+		s = "+"
+	}
+	return append(ms, Msg{Cmd: AUTHENTICATE, Args: []string{s}})
+}
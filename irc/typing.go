@@ -0,0 +1,24 @@
+package irc
+
+// The IRCv3 +typing client tag.
+
+const (
+	// TypingTag tags a TAGMSG with the sender's
+	// typing state: TypingActive, TypingPaused,
+	// or TypingDone.
+	TypingTag = "+typing"
+
+	TypingActive = "active"
+	TypingPaused = "paused"
+	TypingDone   = "done"
+)
+
+// Typing returns a TAGMSG notifying the
+// target of the sender's typing state.
+func Typing(target, state string) Msg {
+	return Msg{
+		Cmd:  TAGMSG,
+		Args: []string{target},
+		Tags: map[string]string{TypingTag: state},
+	}
+}
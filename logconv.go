@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+// A logEvent is a log entry in the form common
+// to the log formats that velour converts its
+// logs to and from.
+type logEvent struct {
+	Time time.Time `json:"time"`
+
+	// Kind is message, action, join, part,
+	// quit, nick, topic, or event.
+	Kind string `json:"kind"`
+
+	// Nick is who sent the message or caused
+	// the event. For a nick event it is the
+	// previous nick, and Text is the new nick.
+	Nick string `json:"nick,omitempty"`
+
+	// Text is the text of a message or action,
+	// the reason of a part or quit, the topic,
+	// or the text of another event.
+	Text string `json:"text,omitempty"`
+
+	// Tags are the message's IRCv3 tags.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ParseLogLine returns the event of an entry of a velour log.
+func parseLogLine(l logLine) logEvent {
+	e := logEvent{Time: l.t, Kind: "event", Text: l.text}
+	text := l.text
+	if strings.HasPrefix(text, "@") {
+		var tags string
+		tags, text, _ = strings.Cut(text[1:], " ")
+		e.Tags = irc.ParseTags(tags)
+	}
+	if text == "" {
+		return e
+	}
+	switch text[0] {
+	case '<':
+		if who, msg, ok := strings.Cut(text[1:], "> "); ok {
+			return logEvent{Time: l.t, Kind: "message", Nick: who, Text: msg, Tags: e.Tags}
+		}
+	case '*':
+		if who, msg, ok := strings.Cut(strings.TrimPrefix(text, "* "), " "); ok {
+			return logEvent{Time: l.t, Kind: "action", Nick: who, Text: msg, Tags: e.Tags}
+		}
+	case '+':
+		who, account, _ := strings.Cut(text[1:], " ")
+		if account == "" {
+			return logEvent{Time: l.t, Kind: "join", Nick: who}
+		}
+		if strings.HasPrefix(account, "[") && strings.HasSuffix(account, "]") {
+			account = account[1 : len(account)-1]
+			return logEvent{Time: l.t, Kind: "join", Nick: who, Tags: map[string]string{"account": account}}
+		}
+	case '-':
+		who, rest, _ := strings.Cut(text[1:], " ")
+		switch {
+		case rest == "":
+			return logEvent{Time: l.t, Kind: "part", Nick: who}
+		case rest == "quit":
+			return logEvent{Time: l.t, Kind: "quit", Nick: who}
+		case strings.HasPrefix(rest, "quit: "):
+			return logEvent{Time: l.t, Kind: "quit", Nick: who, Text: rest[len("quit: "):]}
+		}
+	case '~':
+		if prev, cur, ok := strings.Cut(text[1:], " → "); ok {
+			return logEvent{Time: l.t, Kind: "nick", Nick: prev, Text: cur}
+		}
+	case '=':
+		if topic, ok := strings.CutPrefix(text, "=topic: "); ok {
+			return logEvent{Time: l.t, Kind: "topic", Text: topic}
+		}
+		if who, rest, _ := strings.Cut(text[1:], " "); strings.HasPrefix(rest, "topic: ") {
+			return logEvent{Time: l.t, Kind: "topic", Nick: who, Text: rest[len("topic: "):]}
+		}
+	}
+	e.Text = strings.TrimLeft(text[:1], "=+-~") + text[1:]
+	return e
+}
+
+// Entry returns the velour log entry of the event.
+func (e logEvent) entry() string {
+	var s string
+	switch e.Kind {
+	case "message":
+		s = "<" + e.Nick + "> " + e.Text
+	case "action":
+		s = "* " + e.Nick + " " + e.Text
+	case "join":
+		s = "+" + e.Nick
+		if a := e.Tags["account"]; a != "" {
+			s += " [" + a + "]"
+		}
+		return s
+	case "part":
+		return "-" + e.Nick
+	case "quit":
+		if s = "-" + e.Nick + " quit"; e.Text != "" {
+			s += ": " + e.Text
+		}
+		return s
+	case "nick":
+		return "~" + e.Nick + " → " + e.Text
+	case "topic":
+		if e.Nick == "" {
+			return "=topic: " + e.Text
+		}
+		return "=" + e.Nick + " topic: " + e.Text
+	default:
+		return "=" + e.Text
+	}
+	if len(e.Tags) > 0 {
+		s = "@" + irc.FormatTags(e.Tags) + " " + s
+	}
+	return s
+}
+
+// A logFormat converts log events to and
+// from the log format of another client.
+type logFormat struct {
+	// Daily is true if the format's logs are a directory
+	// of files, one per day, named YYYY-MM-DD.log, whose
+	// lines have a time but not a date.
+	daily bool
+
+	// Write writes the events of the channel or user.
+	write func(w io.Writer, target string, evs []logEvent) error
+
+	// Read reads events. The day is that of
+	// the file for daily formats, and otherwise zero.
+	read func(r io.Reader, day time.Time) ([]logEvent, error)
+}
+
+// LogFormats are the log formats, keyed by name.
+var logFormats = map[string]logFormat{
+	"irssi":   {write: writeIrssi, read: readIrssi},
+	"weechat": {write: writeWeechat, read: readWeechat},
+	"znc":     {daily: true, write: writeZNC, read: readZNC},
+	"json":    {write: writeJSON, read: readJSON},
+}
+
+// Lines returns the lines of the event's text;
+// formats other than velour's and JSON
+// write each line of a message separately.
+func (e logEvent) lines() []string {
+	return strings.Split(strings.TrimRight(e.Text, "\n"), "\n")
+}
+
+func writeIrssi(w io.Writer, target string, evs []logEvent) error {
+	b := bufio.NewWriter(w)
+	day := ""
+	for i, e := range evs {
+		t := e.Time.Local()
+		if i == 0 {
+			fmt.Fprintf(b, "--- Log opened %s\n", t.Format("Mon Jan 02 15:04:05 2006"))
+		} else if d := t.Format("Mon Jan 02 2006"); d != day {
+			fmt.Fprintf(b, "--- Day changed %s\n", d)
+		}
+		day = t.Format("Mon Jan 02 2006")
+		stamp := t.Format("15:04:05")
+		switch e.Kind {
+		case "message":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "%s <%s> %s\n", stamp, e.Nick, l)
+			}
+		case "action":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "%s  * %s %s\n", stamp, e.Nick, l)
+			}
+		case "join":
+			fmt.Fprintf(b, "%s -!- %s has joined %s\n", stamp, e.Nick, target)
+		case "part":
+			fmt.Fprintf(b, "%s -!- %s has left %s [%s]\n", stamp, e.Nick, target, e.Text)
+		case "quit":
+			fmt.Fprintf(b, "%s -!- %s has quit [%s]\n", stamp, e.Nick, e.Text)
+		case "nick":
+			fmt.Fprintf(b, "%s -!- %s is now known as %s\n", stamp, e.Nick, e.Text)
+		case "topic":
+			if e.Nick == "" {
+				fmt.Fprintf(b, "%s -!- Topic for %s: %s\n", stamp, target, e.Text)
+			} else {
+				fmt.Fprintf(b, "%s -!- %s changed the topic of %s to: %s\n", stamp, e.Nick, target, e.Text)
+			}
+		default:
+			fmt.Fprintf(b, "%s -!- %s\n", stamp, e.Text)
+		}
+	}
+	if len(evs) > 0 {
+		fmt.Fprintf(b, "--- Log closed %s\n", evs[len(evs)-1].Time.Local().Format("Mon Jan 02 15:04:05 2006"))
+	}
+	return b.Flush()
+}
+
+var (
+	irssiJoin   = regexp.MustCompile(`^(\S+)(?: \[[^\]]*\])? has joined \S+$`)
+	irssiPart   = regexp.MustCompile(`^(\S+)(?: \[[^\]]*\])? has left \S+(?: \[(.*)\])?$`)
+	irssiQuit   = regexp.MustCompile(`^(\S+)(?: \[[^\]]*\])? has quit(?: \[(.*)\])?$`)
+	irssiNick   = regexp.MustCompile(`^(\S+) is now known as (\S+)$`)
+	irssiTopic  = regexp.MustCompile(`^(\S+) changed the topic of \S+ to: (.*)$`)
+	irssiTopic2 = regexp.MustCompile(`^Topic for \S+: (.*)$`)
+)
+
+func readIrssi(r io.Reader, _ time.Time) ([]logEvent, error) {
+	var evs []logEvent
+	var day time.Time
+	s := newLogScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if rest, ok := strings.CutPrefix(line, "--- Log opened "); ok {
+			if t, err := time.ParseInLocation("Mon Jan 02 15:04:05 2006", rest, time.Local); err == nil {
+				day = t
+			}
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "--- Day changed "); ok {
+			if t, err := time.ParseInLocation("Mon Jan 02 2006", rest, time.Local); err == nil {
+				day = t
+			}
+			continue
+		}
+		stamp, text, ok := strings.Cut(line, " ")
+		t, err := clockTime(day, stamp)
+		if !ok || err != nil {
+			continue
+		}
+		e := logEvent{Time: t, Kind: "event"}
+		switch {
+		case strings.HasPrefix(text, "<"):
+			who, msg, ok := strings.Cut(text[1:], "> ")
+			if !ok {
+				e.Text = text
+				break
+			}
+			e.Kind, e.Nick, e.Text = "message", trimMode(who), msg
+		case strings.HasPrefix(text, " * "):
+			who, msg, _ := strings.Cut(text[3:], " ")
+			e.Kind, e.Nick, e.Text = "action", who, msg
+		case strings.HasPrefix(text, "-!- "):
+			e = matchEvent(t, text[4:], []eventPattern{
+				{irssiJoin, "join"},
+				{irssiPart, "part"},
+				{irssiQuit, "quit"},
+				{irssiNick, "nick"},
+				{irssiTopic, "topic"},
+				{irssiTopic2, "topic"},
+			})
+		default:
+			e.Text = text
+		}
+		evs = append(evs, e)
+	}
+	return evs, s.Err()
+}
+
+func writeWeechat(w io.Writer, target string, evs []logEvent) error {
+	b := bufio.NewWriter(w)
+	for _, e := range evs {
+		stamp := e.Time.Local().Format("2006-01-02 15:04:05")
+		switch e.Kind {
+		case "message":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "%s\t%s\t%s\n", stamp, e.Nick, l)
+			}
+		case "action":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "%s\t *\t%s %s\n", stamp, e.Nick, l)
+			}
+		case "join":
+			fmt.Fprintf(b, "%s\t-->\t%s has joined %s\n", stamp, e.Nick, target)
+		case "part":
+			fmt.Fprintf(b, "%s\t<--\t%s has left %s (%s)\n", stamp, e.Nick, target, e.Text)
+		case "quit":
+			fmt.Fprintf(b, "%s\t<--\t%s has quit (%s)\n", stamp, e.Nick, e.Text)
+		case "nick":
+			fmt.Fprintf(b, "%s\t--\t%s is now known as %s\n", stamp, e.Nick, e.Text)
+		case "topic":
+			if e.Nick == "" {
+				fmt.Fprintf(b, "%s\t--\tTopic for %s is %q\n", stamp, target, e.Text)
+			} else {
+				fmt.Fprintf(b, "%s\t--\t%s has changed topic for %s to %q\n", stamp, e.Nick, target, e.Text)
+			}
+		default:
+			fmt.Fprintf(b, "%s\t--\t%s\n", stamp, e.Text)
+		}
+	}
+	return b.Flush()
+}
+
+var (
+	weechatJoin   = regexp.MustCompile(`^(\S+)(?: \([^)]*\))? has joined \S+$`)
+	weechatPart   = regexp.MustCompile(`^(\S+)(?: \([^)]*\))? has left \S+(?: \((.*)\))?$`)
+	weechatQuit   = regexp.MustCompile(`^(\S+)(?: \([^)]*\))? has quit(?: \((.*)\))?$`)
+	weechatNick   = regexp.MustCompile(`^(\S+) is now known as (\S+)$`)
+	weechatTopic  = regexp.MustCompile(`^(\S+) has changed topic for \S+ (?:from ".*" )?to "(.*)"$`)
+	weechatTopic2 = regexp.MustCompile(`^Topic for \S+ is "(.*)"$`)
+)
+
+func readWeechat(r io.Reader, _ time.Time) ([]logEvent, error) {
+	var evs []logEvent
+	s := newLogScanner(r)
+	for s.Scan() {
+		f := strings.SplitN(s.Text(), "\t", 3)
+		if len(f) != 3 {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", f[0], time.Local)
+		if err != nil {
+			continue
+		}
+		e := logEvent{Time: t, Kind: "event", Text: f[2]}
+		switch prefix := strings.TrimSpace(f[1]); prefix {
+		case "*":
+			who, msg, _ := strings.Cut(f[2], " ")
+			e.Kind, e.Nick, e.Text = "action", who, msg
+		case "-->", "<--", "--", "":
+			e = matchEvent(t, f[2], []eventPattern{
+				{weechatJoin, "join"},
+				{weechatPart, "part"},
+				{weechatQuit, "quit"},
+				{weechatNick, "nick"},
+				{weechatTopic, "topic"},
+				{weechatTopic2, "topic"},
+			})
+		default:
+			e.Kind, e.Nick = "message", trimMode(prefix)
+		}
+		if e.Kind == "topic" {
+			if topic, err := strconv.Unquote(`"` + e.Text + `"`); err == nil {
+				e.Text = topic
+			}
+		}
+		evs = append(evs, e)
+	}
+	return evs, s.Err()
+}
+
+func writeZNC(w io.Writer, _ string, evs []logEvent) error {
+	b := bufio.NewWriter(w)
+	for _, e := range evs {
+		stamp := e.Time.Local().Format("15:04:05")
+		switch e.Kind {
+		case "message":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "[%s] <%s> %s\n", stamp, e.Nick, l)
+			}
+		case "action":
+			for _, l := range e.lines() {
+				fmt.Fprintf(b, "[%s] * %s %s\n", stamp, e.Nick, l)
+			}
+		case "join":
+			fmt.Fprintf(b, "[%s] *** Joins: %s\n", stamp, e.Nick)
+		case "part":
+			fmt.Fprintf(b, "[%s] *** Parts: %s (%s)\n", stamp, e.Nick, e.Text)
+		case "quit":
+			fmt.Fprintf(b, "[%s] *** Quits: %s (%s)\n", stamp, e.Nick, e.Text)
+		case "nick":
+			fmt.Fprintf(b, "[%s] *** %s is now known as %s\n", stamp, e.Nick, e.Text)
+		case "topic":
+			fmt.Fprintf(b, "[%s] *** %s changes topic to '%s'\n", stamp, e.Nick, e.Text)
+		default:
+			fmt.Fprintf(b, "[%s] *** %s\n", stamp, e.Text)
+		}
+	}
+	return b.Flush()
+}
+
+var (
+	zncJoin  = regexp.MustCompile(`^Joins: (\S+)(?: \([^)]*\))?$`)
+	zncPart  = regexp.MustCompile(`^Parts: (\S+)(?: \([^)]*\))? \((.*)\)$`)
+	zncQuit  = regexp.MustCompile(`^Quits: (\S+)(?: \([^)]*\))? \((.*)\)$`)
+	zncNick  = regexp.MustCompile(`^(\S+) is now known as (\S+)$`)
+	zncTopic = regexp.MustCompile(`^(\S*) changes topic to '(.*)'$`)
+)
+
+func readZNC(r io.Reader, day time.Time) ([]logEvent, error) {
+	var evs []logEvent
+	s := newLogScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+		stamp, text, ok := strings.Cut(line[1:], "] ")
+		t, err := clockTime(day, stamp)
+		if !ok || err != nil {
+			continue
+		}
+		e := logEvent{Time: t, Kind: "event", Text: text}
+		switch {
+		case strings.HasPrefix(text, "<"):
+			if who, msg, ok := strings.Cut(text[1:], "> "); ok {
+				e.Kind, e.Nick, e.Text = "message", trimMode(who), msg
+			}
+		case strings.HasPrefix(text, "-"):
+			// A notice.
+			if who, msg, ok := strings.Cut(text[1:], "- "); ok {
+				e.Kind, e.Nick, e.Text = "message", who, msg
+			}
+		case strings.HasPrefix(text, "*** "):
+			e = matchEvent(t, text[4:], []eventPattern{
+				{zncJoin, "join"},
+				{zncPart, "part"},
+				{zncQuit, "quit"},
+				{zncNick, "nick"},
+				{zncTopic, "topic"},
+			})
+		case strings.HasPrefix(text, "* "):
+			who, msg, _ := strings.Cut(text[2:], " ")
+			e.Kind, e.Nick, e.Text = "action", who, msg
+		}
+		evs = append(evs, e)
+	}
+	return evs, s.Err()
+}
+
+func writeJSON(w io.Writer, _ string, evs []logEvent) error {
+	b := bufio.NewWriter(w)
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	for _, e := range evs {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return b.Flush()
+}
+
+func readJSON(r io.Reader, _ time.Time) ([]logEvent, error) {
+	var evs []logEvent
+	dec := json.NewDecoder(r)
+	for {
+		var e logEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			return evs, nil
+		} else if err != nil {
+			return evs, err
+		}
+		evs = append(evs, e)
+	}
+}
+
+// An eventPattern is a regular expression matching the
+// text of an event of the kind. Its first submatch is the
+// nick, and its second, if any, is the event's text.
+type eventPattern struct {
+	re   *regexp.Regexp
+	kind string
+}
+
+// MatchEvent returns the event of the first pattern that
+// matches the text, or an event of kind event if none do.
+func matchEvent(t time.Time, text string, pats []eventPattern) logEvent {
+	for _, p := range pats {
+		m := p.re.FindStringSubmatch(text)
+		switch {
+		case m == nil:
+			continue
+		case p.re.NumSubexp() == 1 && p.kind == "topic":
+			return logEvent{Time: t, Kind: p.kind, Text: m[1]}
+		case len(m) == 2:
+			return logEvent{Time: t, Kind: p.kind, Nick: m[1]}
+		default:
+			return logEvent{Time: t, Kind: p.kind, Nick: m[1], Text: m[2]}
+		}
+	}
+	return logEvent{Time: t, Kind: "event", Text: text}
+}
+
+// ClockTime returns the time on the day
+// of a clock time, HH:MM or HH:MM:SS.
+func clockTime(day time.Time, clock string) (time.Time, error) {
+	t, err := time.Parse("15:04:05", clock)
+	if err != nil {
+		if t, err = time.Parse("15:04", clock); err != nil {
+			return time.Time{}, err
+		}
+	}
+	y, m, d := day.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+}
+
+// TrimMode returns the nick without
+// a leading channel mode character.
+func trimMode(nick string) string {
+	return strings.TrimLeft(nick, " @+%&~")
+}
+
+// LogCmd runs the log subcommand, returning the exit status:
+//
+//	velour log export <format> <dir> <file>|<outdir>
+//	velour log import <format> <file>|<indir> <dir>
+//
+// The dir is the velour log directory of a channel or user.
+func logCmd(args []string) int {
+	if len(args) != 4 || args[0] != "export" && args[0] != "import" {
+		fmt.Fprintln(os.Stderr, "usage: velour log export <format> <dir> <file>|<outdir>")
+		fmt.Fprintln(os.Stderr, "       velour log import <format> <file>|<indir> <dir>")
+		return 1
+	}
+	f, ok := logFormats[args[1]]
+	if !ok {
+		var names []string
+		for n := range logFormats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "velour: unknown log format %s; want one of %s\n", args[1], strings.Join(names, ", "))
+		return 1
+	}
+	var err error
+	if args[0] == "export" {
+		err = exportLog(f, args[2], args[3])
+	} else {
+		err = importLog(f, args[2], args[3])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "velour:", err)
+		return 1
+	}
+	return 0
+}
+
+// ExportLog writes the velour log in dir
+// to out in the format.
+func exportLog(f logFormat, dir, out string) error {
+	files := logFiles(dir)
+	if len(files) == 0 {
+		return fmt.Errorf("%s: no logs", dir)
+	}
+	target := unlogName(filepath.Base(dir))
+	var evs []logEvent
+	for _, path := range files {
+		lines, err := readLog(path)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			evs = append(evs, parseLogLine(l))
+		}
+	}
+	if !f.daily {
+		return writeFile(out, func(w io.Writer) error { return f.write(w, target, evs) })
+	}
+	if err := os.MkdirAll(out, 0700); err != nil {
+		return err
+	}
+	for len(evs) > 0 {
+		day := evs[0].Time.Local().Format(logDayFormat)
+		n := 1
+		for n < len(evs) && evs[n].Time.Local().Format(logDayFormat) == day {
+			n++
+		}
+		err := writeFile(filepath.Join(out, day+".log"), func(w io.Writer) error {
+			return f.write(w, target, evs[:n])
+		})
+		if err != nil {
+			return err
+		}
+		evs = evs[n:]
+	}
+	return nil
+}
+
+// WriteFile writes the file at path with write.
+// It is written to a temporary file in the same
+// directory, which then replaces the file, so that
+// a failed write leaves the old file intact.
+func writeFile(path string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return nil
+}
+
+// ImportLog reads the log at in, in the format,
+// merging its events into the velour log in dir.
+func importLog(f logFormat, in, dir string) error {
+	var evs []logEvent
+	read := func(path string, day time.Time) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		es, err := f.read(file, day)
+		evs = append(evs, es...)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	}
+	if !f.daily {
+		if err := read(in, time.Time{}); err != nil {
+			return err
+		}
+	} else {
+		files := logFiles(in)
+		if len(files) == 0 {
+			return fmt.Errorf("%s: no logs", in)
+		}
+		for _, path := range files {
+			name := strings.TrimSuffix(filepath.Base(path), ".log")
+			day, _ := time.ParseInLocation(logDayFormat, name, time.Local)
+			if err := read(path, day); err != nil {
+				return err
+			}
+		}
+	}
+	return mergeLog(dir, evs)
+}
+
+// MergeLog merges events into the velour log in dir,
+// rewriting the files of their days in time order.
+// Entries already in the log are not duplicated.
+func mergeLog(dir string, evs []logEvent) error {
+	days := map[string][]logLine{}
+	for _, e := range evs {
+		day := e.Time.Local().Format(logDayFormat)
+		days[day] = append(days[day], logLine{e.Time, e.entry()})
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for day, lines := range days {
+		path := filepath.Join(dir, day+".log")
+		old, err := readLog(path)
+		if err != nil && !os.IsNotExist(err) {
+			// Rewriting the file would lose
+			// the entries that were not read.
+			return err
+		}
+		lines = append(old, lines...)
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i].t.Before(lines[j].t) })
+		err = writeFile(path, func(w io.Writer) error {
+			b := bufio.NewWriter(w)
+			seen := map[logLine]bool{}
+			for _, l := range lines {
+				l.t = l.t.Truncate(time.Millisecond)
+				k := logLine{l.t.UTC(), l.text}
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+				b.WriteString(formatEntry(l.t, l.text))
+			}
+			return b.Flush()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnlogName returns the name of which
+// a log directory name is the logName.
+func unlogName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '_' && i+2 < len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
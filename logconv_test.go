@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		text string
+		want logEvent
+	}{
+		{"<alice> hi", logEvent{Kind: "message", Nick: "alice", Text: "hi"}},
+		{"@k=v <alice> hi", logEvent{Kind: "message", Nick: "alice", Text: "hi", Tags: map[string]string{"k": "v"}}},
+		{"* alice waves", logEvent{Kind: "action", Nick: "alice", Text: "waves"}},
+		{"+alice", logEvent{Kind: "join", Nick: "alice"}},
+		{"+alice [acct]", logEvent{Kind: "join", Nick: "alice", Tags: map[string]string{"account": "acct"}}},
+		{"-alice", logEvent{Kind: "part", Nick: "alice"}},
+		{"-alice quit", logEvent{Kind: "quit", Nick: "alice"}},
+		{"-alice quit: r", logEvent{Kind: "quit", Nick: "alice", Text: "r"}},
+		{"~a → b", logEvent{Kind: "nick", Nick: "a", Text: "b"}},
+		{"=topic: t", logEvent{Kind: "topic", Text: "t"}},
+		{"=who topic: t", logEvent{Kind: "topic", Nick: "who", Text: "t"}},
+		{"=Connected", logEvent{Kind: "event", Text: "Connected"}},
+	}
+	for _, test := range tests {
+		if got := parseLogLine(logLine{text: test.text}); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseLogLine(%q)=%+v, want %+v", test.text, got, test.want)
+		}
+		if got := test.want.entry(); got != test.text {
+			t.Errorf("entry of %+v=%q, want %q", test.want, got, test.text)
+		}
+	}
+}
+
+// RoundTripEntries are velour log entries
+// that survive conversion to every format.
+var roundTripEntries = []string{
+	"<alice> hi",
+	"* alice waves",
+	"+bob",
+	"-bob",
+	"-carol quit: bye",
+	"~dave → erin",
+	"=topic: old topic",
+	"=alice topic: new topic",
+	"=the server said something",
+}
+
+func TestLogFormatRoundTrip(t *testing.T) {
+	day := time.Date(2006, 1, 2, 15, 4, 0, 0, time.Local)
+	var evs []logEvent
+	for i, text := range roundTripEntries {
+		evs = append(evs, parseLogLine(logLine{day.Add(time.Duration(i) * time.Second), text}))
+	}
+	for name, f := range logFormats {
+		var b bytes.Buffer
+		if err := f.write(&b, "#go", evs); err != nil {
+			t.Errorf("%s: write failed: %v", name, err)
+			continue
+		}
+		got, err := f.read(&b, day)
+		if err != nil {
+			t.Errorf("%s: read failed: %v", name, err)
+			continue
+		}
+		if len(got) != len(evs) {
+			t.Errorf("%s: got %d events, want %d", name, len(got), len(evs))
+			continue
+		}
+		for i, e := range got {
+			if !e.Time.Equal(evs[i].Time) || e.entry() != roundTripEntries[i] {
+				t.Errorf("%s: got %s %q, want %s %q", name, e.Time, e.entry(), evs[i].Time, roundTripEntries[i])
+			}
+		}
+	}
+}
+
+func TestMergeLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "_23go")
+	day := time.Date(2006, 1, 2, 23, 59, 59, 0, time.Local)
+	evs := []logEvent{
+		{Time: day, Kind: "message", Nick: "alice", Text: "hi"},
+		{Time: day.Add(time.Second), Kind: "message", Nick: "bob", Text: "hello"},
+		{Time: day.Add(2 * time.Second), Kind: "join", Nick: "carol"},
+	}
+	if err := mergeLog(dir, evs[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeLog(dir, evs); err != nil {
+		t.Fatal(err)
+	}
+	files := logFiles(dir)
+	if len(files) != 2 {
+		t.Fatalf("got log files %v, want one for each of two days", files)
+	}
+	var got []string
+	for _, path := range files {
+		lines, err := readLog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range lines {
+			got = append(got, l.text)
+		}
+	}
+	want := []string{"<alice> hi", "<bob> hello", "+carol"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged log=%q, want %q", got, want)
+	}
+}
+
+func TestMergeLogWhileLogging(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2006, 1, 2, 15, 4, 5, 0, time.Local)
+	l := openLog(dir)
+	defer l.close()
+	if err := l.write(day, "<alice> before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mergeLog(dir, []logEvent{{Time: day.Add(time.Second), Kind: "message", Nick: "bob", Text: "imported"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.write(day.Add(2*time.Second), "<alice> after"); err != nil {
+		t.Fatal(err)
+	}
+	lines, err := readLog(filepath.Join(dir, day.Format(logDayFormat)+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range lines {
+		got = append(got, l.text)
+	}
+	want := []string{"<alice> before", "<bob> imported", "<alice> after"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("log=%q, want %q", got, want)
+	}
+}
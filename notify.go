@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// NotifyDelay is how long the notifier waits after
+	// an event for others, so that a burst of events
+	// is given to a single run of the utility program.
+	notifyDelay = 500 * time.Millisecond
+
+	// NotifyInterval is the least amount of time
+	// between runs of the utility program.
+	notifyInterval = 5 * time.Second
+
+	// MaxNotifications is the most events with which
+	// the utility program is run. Older events
+	// beyond this are dropped.
+	maxNotifications = 100
+
+	// NotifyTimeout is how long the utility program may
+	// run before it is killed, so that a program that
+	// hangs does not hold back later notifications.
+	notifyTimeout = 30 * time.Second
+)
+
+// A notification is an event of which
+// the utility program is notified.
+type notification struct {
+	Time time.Time `json:"time"`
+
+	// Event is the kind of event: highlight,
+	// private, channel, or watch.
+	Event string `json:"event"`
+
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+	Nick    string `json:"nick"`
+	Text    string `json:"text"`
+
+	// Util is the utility program
+	// of the event's session.
+	util string
+}
+
+// Notifications are the notifications of all
+// sessions, collected by runNotifications.
+var notifications = make(chan notification)
+
+// A notifier chooses the events of a session
+// of which its utility program is notified.
+type notifier struct {
+	// Util is the utility program.
+	util string
+
+	// Events are the kinds of events of which to
+	// notify, and channels are the lower-case
+	// channels of whose every message to notify.
+	events, channels map[string]bool
+}
+
+// NewNotifier returns a notifier that runs the utility
+// program for the events in the comma-separated list:
+// highlight, private, watch, and the names of channels.
+func newNotifier(util, list string) (*notifier, error) {
+	n := &notifier{
+		util:     util,
+		events:   map[string]bool{},
+		channels: map[string]bool{},
+	}
+	for _, e := range splitList(list) {
+		switch {
+		case isChannel(e):
+			n.channels[strings.ToLower(e)] = true
+		case e == "highlight", e == "private", e == "watch":
+			n.events[e] = true
+		default:
+			return nil, errors.New("unknown notify event " + e + "; want highlight, private, watch, or a channel")
+		}
+	}
+	return n, nil
+}
+
+// NotifyMsg notifies of a message from who to the window,
+// unless we sent it, it is replayed, or it is not one of
+// the events of which to notify.
+func (s *session) notifyMsg(w *win, who, text string, t time.Time) {
+	n := s.notifier
+	if n == nil || w.replaying || strings.EqualFold(who, s.nick) {
+		return
+	}
+	e := ""
+	switch {
+	case !isChannel(w.target):
+		if n.events["private"] {
+			e = "private"
+		}
+	case w.highlighted(text) && n.events["highlight"]:
+		e = "highlight"
+	case n.channels[strings.ToLower(w.target)]:
+		e = "channel"
+	}
+	if e == "" {
+		return
+	}
+	if strings.HasPrefix(text, actionPrefix) {
+		text = "* " + who + strings.TrimRight(text[len(actionPrefix):], "\x01")
+	}
+	notifications <- notification{Time: t, Event: e, Network: s.name(), Channel: w.target, Nick: who, Text: text, util: n.util}
+}
+
+// NotifyWatch notifies of a watched
+// nick coming online or going offline.
+func (s *session) notifyWatch(nick string, online bool) {
+	if s.notifier == nil || !s.notifier.events["watch"] {
+		return
+	}
+	text := "offline"
+	if online {
+		text = "online"
+	}
+	notifications <- notification{Time: time.Now(), Event: "watch", Network: s.name(), Nick: nick, Text: text, util: s.notifier.util}
+}
+
+// RunNotifications collects the notifications from c, calling
+// run with them delay after the first arrives, but no sooner than
+// interval after its last call returned. Bursts of events from all
+// sessions are coalesced, so the interval is process-wide.
+func runNotifications(c <-chan notification, delay, interval time.Duration, run func([]notification)) {
+	var (
+		batch   []notification
+		last    time.Time
+		timer   <-chan time.Time
+		running bool
+		done    = make(chan bool)
+	)
+	for {
+		select {
+		case e := <-c:
+			if batch = append(batch, e); len(batch) > maxNotifications {
+				batch = batch[len(batch)-maxNotifications:]
+			}
+			if timer == nil && !running {
+				d := interval - time.Since(last)
+				if d < delay {
+					d = delay
+				}
+				timer = time.After(d)
+			}
+
+		case <-timer:
+			timer = nil
+			running = true
+			go func(b []notification) {
+				run(b)
+				done <- true
+			}(batch)
+			batch = nil
+
+		case <-done:
+			running = false
+			last = time.Now()
+			if len(batch) > 0 {
+				timer = time.After(interval)
+			}
+		}
+	}
+}
+
+// RunUtils runs the utility program of each of
+// the notifications once, with its notifications.
+func runUtils(b []notification) {
+	var utils []string
+	byUtil := map[string][]notification{}
+	for _, e := range b {
+		if byUtil[e.util] == nil {
+			utils = append(utils, e.util)
+		}
+		byUtil[e.util] = append(byUtil[e.util], e)
+	}
+	for _, u := range utils {
+		runUtil(u, byUtil[u])
+	}
+}
+
+// RunUtil runs the utility program with the nick of the latest
+// notification as its argument, the notifications as JSON
+// objects, one per line, on its standard input, and the fields
+// of the latest in the environment as VELOUR_EVENT,
+// VELOUR_NETWORK, VELOUR_CHANNEL, VELOUR_NICK, and VELOUR_TEXT,
+// with their number as VELOUR_COUNT.
+func runUtil(util string, b []notification) {
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	for _, e := range b {
+		enc.Encode(e)
+	}
+	e := b[len(b)-1]
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, util, e.Nick)
+	cmd.Stdin = &in
+	cmd.Env = append(os.Environ(),
+		"VELOUR_EVENT="+e.Event,
+		"VELOUR_NETWORK="+e.Network,
+		"VELOUR_CHANNEL="+e.Channel,
+		"VELOUR_NICK="+e.Nick,
+		"VELOUR_TEXT="+e.Text,
+		"VELOUR_COUNT="+strconv.Itoa(len(b)))
+	if err := cmd.Run(); ctx.Err() != nil {
+		log.Printf("Killed util (%s) after %v\n", util, notifyTimeout)
+	} else if err != nil {
+		log.Printf("Error running util (%s): %v\n", util, err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewNotifier(t *testing.T) {
+	n, err := newNotifier("notify-send", "highlight, watch,#Go,&local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"highlight": true, "watch": true}; !reflect.DeepEqual(n.events, want) {
+		t.Errorf("events=%v, want %v", n.events, want)
+	}
+	if want := map[string]bool{"#go": true, "&local": true}; !reflect.DeepEqual(n.channels, want) {
+		t.Errorf("channels=%v, want %v", n.channels, want)
+	}
+	if _, err := newNotifier("notify-send", "highlight,mentions"); err == nil {
+		t.Error("newNotifier with an unknown event succeeded")
+	}
+}
+
+func TestRunNotifications(t *testing.T) {
+	const (
+		delay    = 50 * time.Millisecond
+		interval = 200 * time.Millisecond
+	)
+	type run struct {
+		b          []notification
+		begin, end time.Time
+	}
+	c := make(chan notification)
+	runs := make(chan run)
+	go runNotifications(c, delay, interval, func(b []notification) {
+		begin := time.Now()
+		time.Sleep(delay)
+		runs <- run{b, begin, time.Now()}
+	})
+
+	for i := 0; i < maxNotifications+10; i++ {
+		c <- notification{Text: strconv.Itoa(i)}
+	}
+	r := <-runs
+	if len(r.b) != maxNotifications || r.b[0].Text != "10" {
+		t.Fatalf("first run had %d notifications from %v, want the last %d of a burst",
+			len(r.b), r.b[0], maxNotifications)
+	}
+
+	c <- notification{Text: "a"}
+	c <- notification{Text: "b"}
+	r2 := <-runs
+	if len(r2.b) != 2 || r2.b[0].Text != "a" || r2.b[1].Text != "b" {
+		t.Errorf("second run had %v, want a and b", r2.b)
+	}
+	if d := r2.begin.Sub(r.end); d < interval {
+		t.Errorf("second run began %v after the first, want at least %v", d, interval)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"9fans.net/go/plan9"
+	"9fans.net/go/plumb"
+)
+
+// DefaultSSLPort is the port used to connect
+// to the server of an ircs:// URL that does
+// not specify one.
+const defaultSSLPort = "6697"
+
+// An ircURL is an irc:// or ircs:// URL, naming a server
+// and, optionally, a channel or user on the server.
+type ircURL struct {
+	// Server is the server's host, and port its
+	// port, or the empty string if not given.
+	server, port string
+
+	// SSL is true for an ircs:// URL.
+	ssl bool
+
+	// Target is the channel or nick, if any,
+	// and key is the channel's key, if any.
+	target, key string
+}
+
+// IsIRCURL returns whether s is an irc:// or ircs:// URL.
+func isIRCURL(s string) bool {
+	s = strings.ToLower(s)
+	return strings.HasPrefix(s, "irc://") || strings.HasPrefix(s, "ircs://")
+}
+
+// ParseIRCURL parses an irc:// or ircs:// URL of the form
+// irc://host[:port]/[target][,isnick][?key=key]. A target
+// is a channel, with # added if it has no channel prefix,
+// unless it is followed by ,isnick. An unescaped #, which
+// begins a URL's fragment, may also begin a channel, as in
+// irc://host/#channel.
+func parseIRCURL(s string) (ircURL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return ircURL{}, err
+	}
+	var v ircURL
+	switch strings.ToLower(u.Scheme) {
+	case "irc":
+	case "ircs":
+		v.ssl = true
+	default:
+		return ircURL{}, errors.New(s + " is not an irc:// URL")
+	}
+	if v.server = u.Hostname(); v.server == "" {
+		return ircURL{}, errors.New(s + " has no server")
+	}
+	v.port = u.Port()
+	v.key = u.Query().Get("key")
+
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" && u.Fragment != "" {
+		// The fragment holds the rest of the URL.
+		frag, query, _ := strings.Cut(u.Fragment, "?")
+		if q, err := url.ParseQuery(query); err == nil && q.Has("key") {
+			v.key = q.Get("key")
+		}
+		path = "#" + frag
+	}
+	target, flags, _ := strings.Cut(path, ",")
+	if target == "" {
+		return v, nil
+	}
+	isNick := false
+	for _, f := range strings.Split(flags, ",") {
+		isNick = isNick || f == "isnick" || f == "isuser"
+	}
+	if !isNick && !strings.ContainsAny(target[:1], "#&+!") {
+		target = "#" + target
+	}
+	v.target = target
+	return v, nil
+}
+
+// IsChannel returns whether the URL's target is a channel.
+func (v ircURL) isChannel() bool {
+	return v.target != "" && strings.ContainsAny(v.target[:1], "#&+!")
+}
+
+// URLProfile returns the name of the profile whose server
+// is the URL's server, the first in sorted order if there
+// are several, or the empty string if there is none.
+func urlProfile(v ircURL, profiles map[string]profile) string {
+	var names []string
+	for name, p := range profiles {
+		for _, s := range p {
+			if name != "" && s.key == "server" && strings.EqualFold(s.val, v.server) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// Plumbed multiplexes the data of the
+// messages plumbed to the irc port.
+var plumbed = make(chan string)
+
+// ListenPlumb sends the data of the messages plumbed
+// to the irc port on plumbed, until the plumber is gone.
+func listenPlumb() {
+	fid, err := plumb.Open("irc", plan9.OREAD)
+	if err != nil {
+		log.Println("Failed to open the irc plumb port: " + err.Error())
+		return
+	}
+	defer fid.Close()
+	r := bufio.NewReader(fid)
+	for {
+		var m plumb.Message
+		if err := m.Recv(r); err != nil {
+			log.Println("Failed to read from the plumber: " + err.Error())
+			return
+		}
+		plumbed <- string(m.Data)
+	}
+}
+
+// OpenURL opens the window of a plumbed irc:// URL, joining
+// its channel, on the session connected to its server, or on
+// a new session, using the server's profile if it has one.
+func openURL(text string) {
+	text = strings.TrimSpace(text)
+	v, err := parseIRCURL(text)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, s := range sessions {
+		_, port, _ := net.SplitHostPort(s.addr)
+		if strings.EqualFold(s.server, v.server) && (v.port == "" || v.port == port) {
+			s.openURL(v)
+			return
+		}
+	}
+	profiles, err := readConfig(configName)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	s, err := newSession(text, profiles)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	sessions = append(sessions, s)
+	s.start()
+}
+
+// OpenURL opens the window of the URL's
+// target, or the server window if it has none.
+func (s *session) openURL(v ircURL) {
+	switch {
+	case v.target == "":
+		s.serverWin.Ctl("show")
+	case !s.connected:
+		s.serverWin.writeMsg("=ERROR: not connected; cannot open " + v.target)
+	case v.key != "":
+		s.chat([]string{v.target, v.key})
+	default:
+		s.chat([]string{v.target})
+	}
+}
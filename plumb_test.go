@@ -0,0 +1,63 @@
+package main
+
+import "testing"
+
+func TestURLSessionCredentials(t *testing.T) {
+	defer func(o options) { opts = o }(opts)
+	opts.pass = "secret"
+	opts.regain = "REGAIN"
+	profiles := map[string]profile{
+		"": {{"sasl", "alice hunter2"}},
+		"libera": {
+			{"sasl", "alice hunter2"},
+			{"server", "irc.libera.chat"},
+		},
+	}
+
+	s, err := newSession("irc://irc.example.com/#go", profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := s.opts; o.pass != "" || o.saslUser != "" || o.saslPass != "" || o.regain != "" {
+		t.Errorf("session of a URL without a profile has pass=%q sasl=%q %q regain=%q, want none",
+			o.pass, o.saslUser, o.saslPass, o.regain)
+	}
+
+	s, err = newSession("ircs://irc.libera.chat/#go", profiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := s.opts; o.pass != "secret" || o.saslUser != "alice" || o.saslPass != "hunter2" {
+		t.Errorf("session of a URL with a profile has pass=%q sasl=%q %q, want secret alice hunter2",
+			o.pass, o.saslUser, o.saslPass)
+	}
+}
+
+func TestParseIRCURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want ircURL
+	}{
+		{"irc://h/#c", ircURL{server: "h", target: "#c"}},
+		{"irc://h/c", ircURL{server: "h", target: "#c"}},
+		{"irc://h/%23c?key=k", ircURL{server: "h", target: "#c", key: "k"}},
+		{"irc://h/#c?key=k", ircURL{server: "h", target: "#c", key: "k"}},
+		{"ircs://h:6697/nick,isnick", ircURL{server: "h", port: "6697", ssl: true, target: "nick"}},
+		{"IRC://h/&c", ircURL{server: "h", target: "&c"}},
+		{"irc://h/+c", ircURL{server: "h", target: "+c"}},
+		{"irc://h", ircURL{server: "h"}},
+		{"irc://h/", ircURL{server: "h"}},
+	}
+	for _, test := range tests {
+		got, err := parseIRCURL(test.url)
+		if err != nil || got != test.want {
+			t.Errorf("parseIRCURL(%q)=%+v, %v, want %+v", test.url, got, err, test.want)
+		}
+	}
+
+	for _, url := range []string{"irc:///#c", "irc:#c", "http://h/#c"} {
+		if v, err := parseIRCURL(url); err == nil {
+			t.Errorf("parseIRCURL(%q)=%+v, want an error", url, v)
+		}
+	}
+}
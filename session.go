@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/velour/velour/irc"
+)
+
+// A session is a connection to a network,
+// with its server window and chat windows.
+type session struct {
+	// Arg is the command line argument naming
+	// the session's profile or server.
+	arg string
+
+	// Network is the name of the network's profile
+	// in the configuration file, if any.
+	network string
+
+	// Opts are the session's options.
+	opts *options
+
+	// client is the IRC client connection.
+	client *irc.Conn
+
+	// Server is the server's address,
+	// and addr is its address and port.
+	server, addr string
+
+	// Nick is our current nick name.
+	nick string
+
+	// serverWin is the server win.
+	serverWin *win
+
+	// Wins are the chat windows,
+	// keyed by lower-case target.
+	wins map[string]*win
+
+	// Connected is true while connected to the server.
+	connected bool
+
+	// Quitting is set to true if the user Dels
+	// the server window.
+	quitting bool
+
+	// Lagging is set to true when the lag to the
+	// server exceeds lagWarning and the user has
+	// been warned.
+	lagging bool
+
+	// Pending maps the labels of sent messages
+	// that have not been echoed to the messages.
+	pending map[string]pendingMsg
+
+	// Watching maps lower-case nicks on
+	// the watch list to their status.
+	watching map[string]*watched
+
+	// Monitoring is true if the server supports
+	// MONITOR, and false if ISON polling is used.
+	monitoring bool
+
+	// IsOn are the lower-case nicks of each ISON
+	// sent without a reply yet, oldest first.
+	isOn [][]string
+
+	// Notifier runs the utility program, if any.
+	notifier *notifier
+
+	// Query is the nick, given by an irc:// URL,
+	// with whom a chat window is opened on start.
+	query string
+}
+
+// A sessionEvent is a connection event, message,
+// or error from a session's client, or the end
+// of the session if all are nil.
+type sessionEvent struct {
+	*session
+	ev  *irc.Event
+	msg *irc.Msg
+	err error
+}
+
+var (
+	// Sessions are the sessions that have not ended.
+	sessions []*session
+
+	// SessionEvents multiplexes the events of all sessions.
+	sessionEvents = make(chan sessionEvent)
+)
+
+// NewSession returns a new session for the command line
+// argument, which is either the name of a network profile,
+// an irc:// or ircs:// URL, or a server address with an
+// optional port. A URL's server uses its profile, if any.
+func newSession(arg string, profiles map[string]profile) (*session, error) {
+	s := &session{
+		arg:      arg,
+		wins:     map[string]*win{},
+		pending:  map[string]pendingMsg{},
+		watching: map[string]*watched{},
+	}
+	var u ircURL
+	isURL := isIRCURL(arg)
+	if isURL {
+		var err error
+		if u, err = parseIRCURL(arg); err != nil {
+			return nil, err
+		}
+	}
+	p, isProfile := profiles[arg]
+	switch {
+	case isProfile:
+		s.network = arg
+	case isURL:
+		s.network = urlProfile(u, profiles)
+		p = profiles[s.network]
+	default:
+		p = profiles[""]
+	}
+	o, server, port, err := p.options()
+	if err != nil {
+		return nil, err
+	}
+	s.opts = o
+	switch {
+	case isProfile && server == "":
+		return nil, fmt.Errorf("network %s has no server", arg)
+	case isProfile:
+		if port == "" {
+			port = defaultPort
+		}
+	case isURL:
+		server = u.server
+		if u.port != "" || s.network == "" {
+			port = u.port
+		}
+		if s.network == "" {
+			// The URL may name any server, such as one
+			// in a link from a message, so the passwords
+			// meant for the user's networks are not sent.
+			o.pass, o.saslUser, o.saslPass, o.regain = "", "", "", ""
+		}
+		if u.ssl {
+			o.ssl = true
+		}
+		if port == "" {
+			port = defaultPort
+			if u.ssl {
+				port = defaultSSLPort
+			}
+		}
+		switch {
+		case u.isChannel() && u.key != "":
+			o.join = joinList(o.join, u.target+":"+u.key)
+		case u.isChannel():
+			o.join = joinList(o.join, u.target)
+		default:
+			s.query = u.target
+		}
+	default:
+		if server, port, err = net.SplitHostPort(arg); err != nil {
+			port = defaultPort
+			server = arg
+		}
+	}
+	if o.regain != "" && o.pass == "" && o.saslPass == "" {
+		return nil, errors.New("regain needs a password for NickServ: give sasl or -p")
+	}
+	s.server = server
+	s.addr = net.JoinHostPort(server, port)
+	s.nick = o.nick
+	s.setWatchList(splitList(o.watch))
+	if o.util != "" {
+		if s.notifier, err = newNotifier(o.util, o.notify); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// JoinList returns the comma-separated list
+// of channels with the channel added.
+func joinList(list, ch string) string {
+	if list == "" {
+		return ch
+	}
+	return list + "," + ch
+}
+
+// Start opens the session's server window
+// and connects to the server.
+func (s *session) start() {
+	o := s.opts
+	s.serverWin = newWin(s, "")
+	// Set Dump handling for the server window.
+	if wd, err := os.Getwd(); err != nil {
+		log.Println("Failed to set dump working directory: " + err.Error())
+	} else {
+		args := make([]string, 0, len(os.Args))
+		for _, a := range dumpArgs(os.Args[:len(os.Args)-flag.NArg()]) {
+			args = append(args, quote(a))
+		}
+		args = append(args, quote(s.arg))
+		s.serverWin.Ctl("dumpdir %s", wd)
+		s.serverWin.Ctl("dump %s", strings.Join(args, " "))
+	}
+
+	s.client = irc.Connect(irc.Config{
+		Addr:     s.addr,
+		Nick:     o.nick,
+		AltNicks: splitList(o.altNicks),
+		Regain:   o.regain,
+		FullName: o.full,
+		Pass:     o.pass,
+		SASLUser: o.saslUser,
+		SASLPass: o.saslPass,
+		SSL:      o.ssl,
+		TrustSSL: o.trust,
+		Bridges:  o.bridges,
+		Autojoin: irc.ParseChannels(o.join),
+		Caps: []string{
+			"away-notify",
+			"account-notify",
+			"extended-join",
+			irc.HistoryCap,
+			irc.EchoCap,
+			irc.LabelCap,
+			irc.MultilineCap,
+			irc.RedactCap,
+		},
+	}, irc.DefaultBackoff)
+	if s.query != "" {
+		s.getWin(s.query)
+	}
+	go s.forward()
+}
+
+// Forward sends the events, messages, and errors
+// of the session's client on sessionEvents, in the
+// order received, until the client gives up.
+func (s *session) forward() {
+	in, errs := s.client.In, s.client.Errors
+	for {
+		select {
+		case ev, ok := <-s.client.Events:
+			if !ok {
+				sessionEvents <- sessionEvent{session: s}
+				return
+			}
+			sessionEvents <- sessionEvent{session: s, ev: &ev}
+
+		case msg, ok := <-in:
+			if !ok {
+				in = nil
+				break
+			}
+			sessionEvents <- sessionEvent{session: s, msg: &msg}
+
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				break
+			}
+			sessionEvents <- sessionEvent{session: s, err: err}
+		}
+	}
+}
+
+// Close ends the session, deleting its
+// windows unless debugging.
+func (s *session) close() {
+	for i, t := range sessions {
+		if t == s {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+	if *debug {
+		return
+	}
+	s.serverWin.del()
+	for _, w := range s.wins {
+		w.del()
+	}
+}
+
+// Quit quits the session's connection
+// with the given reason, if any.
+func (s *session) quit(reason string) {
+	s.quitting = true
+	msg := irc.Msg{Cmd: irc.QUIT}
+	if reason != "" {
+		msg.Args = []string{reason}
+	}
+	s.client.Out <- msg
+}
+
+// Name returns the name of the session's network:
+// its profile, or else its server.
+func (s *session) name() string {
+	if s.network != "" {
+		return s.network
+	}
+	return s.server
+}
+
+func (s *session) getWin(target string) *win {
+	key := strings.ToLower(target)
+	w, ok := s.wins[key]
+	if !ok {
+		w = newWin(s, target)
+		s.wins[key] = w
+		w.writeScrollback(s.opts.scrollback)
+		s.fetchHistory(w)
+	}
+	return w
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// Mkfifo makes a named pipe at path.
+func mkfifo(path string) error {
+	return errors.New("named pipes are not supported")
+}
+
+// CheckPrivateDir returns an error unless the directory
+// at path is ours alone. It is not checked here.
+func checkPrivateDir(path string) error {
+	return nil
+}
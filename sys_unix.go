@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Mkfifo makes a named pipe at path.
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0600)
+}
+
+// CheckPrivateDir returns an error unless the directory at
+// path, not a symbolic link, is ours alone: owned by us
+// and accessible by no one else.
+func checkPrivateDir(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	switch {
+	case !fi.IsDir():
+		return errors.New(path + " is not a directory")
+	case !ok || int(st.Uid) != os.Getuid():
+		return errors.New(path + " is not owned by you")
+	case fi.Mode().Perm() != 0700:
+		return errors.New(path + " is accessible by others; want mode 0700")
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+const (
+	// TypingInterval is the minimum interval between
+	// active typing notifications sent to a target.
+	typingInterval = 3 * time.Second
+
+	// TypingPause is the amount of time without
+	// typing after which we are paused.
+	typingPause = 5 * time.Second
+
+	// TypingActiveTimeout and typingPausedTimeout
+	// are the amounts of time after which others'
+	// active and paused typing states expire.
+	typingActiveTimeout = 6 * time.Second
+	typingPausedTimeout = 30 * time.Second
+
+	// TypingTick is the interval at which
+	// typing states are checked for expiry.
+	typingTick = time.Second
+)
+
+// A typer is someone else's typing state.
+type typer struct {
+	active  bool
+	expires time.Time
+}
+
+// SetTyping sends our typing state to the window's target,
+// if it has changed or, for TypingActive, if typingInterval
+// has passed since it was last sent.
+func (w *win) setTyping(state string) {
+	if w == w.s.serverWin || !w.s.client.HasCap("message-tags") {
+		return
+	}
+	now := time.Now()
+	if state == irc.TypingActive {
+		w.lastKey = now
+	}
+	if state == w.typingState && (state != irc.TypingActive || now.Sub(w.typingSent) < typingInterval) {
+		return
+	}
+	if state == irc.TypingDone && w.typingState == "" {
+		return
+	}
+	w.s.client.Out <- irc.Typing(w.target, state)
+	w.typingSent = now
+	if w.typingState = state; state == irc.TypingDone {
+		w.typingState = ""
+	}
+	w.scheduleTyping()
+}
+
+// DoTyping handles a typing notification from who.
+// Notifications are only shown in open windows.
+func (s *session) doTyping(ch, who, state string) {
+	if who == s.nick {
+		return
+	}
+	if ch == s.nick {
+		ch = who
+	}
+	w, ok := s.wins[strings.ToLower(ch)]
+	if !ok {
+		return
+	}
+	switch state {
+	case irc.TypingActive:
+		w.typers[who] = typer{active: true, expires: time.Now().Add(typingActiveTimeout)}
+	case irc.TypingPaused:
+		w.typers[who] = typer{expires: time.Now().Add(typingPausedTimeout)}
+	default:
+		delete(w.typers, who)
+	}
+	w.updateStatus()
+	w.scheduleTyping()
+}
+
+// StopTyping clears who's typing state,
+// as when they send a message.
+func (w *win) stopTyping(who string) {
+	if _, ok := w.typers[who]; ok {
+		delete(w.typers, who)
+		w.updateStatus()
+	}
+}
+
+// TickTyping expires others' typing states
+// and pauses ours if we have stopped typing.
+func (w *win) tickTyping() {
+	w.typingTimer = nil
+	now := time.Now()
+	n := len(w.typers)
+	for who, t := range w.typers {
+		if now.After(t.expires) {
+			delete(w.typers, who)
+		}
+	}
+	if len(w.typers) != n {
+		w.updateStatus()
+	}
+	if w.typingState == irc.TypingActive && now.Sub(w.lastKey) >= typingPause {
+		w.setTyping(irc.TypingPaused)
+	}
+	w.scheduleTyping()
+}
+
+// ScheduleTyping starts the typing timer
+// if there are typing states to expire.
+func (w *win) scheduleTyping() {
+	if w.typingTimer != nil || len(w.typers) == 0 && w.typingState != irc.TypingActive {
+		return
+	}
+	w.typingTimer = time.AfterFunc(typingTick, func() {
+		winEvents <- winEvent{typingTimeout: true, win: w}
+	})
+}
+
+// ResetTyping clears all typing states,
+// as when the client disconnects.
+func (w *win) resetTyping() {
+	w.typers = make(map[string]typer)
+	w.typingState = ""
+	w.updateStatus()
+}
+
+// TypingStatus returns the status text
+// naming those who are typing.
+func (w *win) typingStatus() string {
+	var who []string
+	for n, t := range w.typers {
+		if t.active {
+			who = append(who, n)
+		}
+	}
+	sort.Strings(who)
+	switch len(who) {
+	case 0:
+		return ""
+	case 1:
+		return who[0] + " is typing…"
+	case 2:
+		return who[0] + " and " + who[1] + " are typing…"
+	default:
+		return "several people are typing…"
+	}
+}
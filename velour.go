@@ -6,14 +6,15 @@ import (
 	"flag"
 	"io"
 	"log"
-	"net"
 	"os"
-	"os/exec"
 	osuser "os/user"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"9fans.net/go/acme"
 	"github.com/velour/velour/irc"
 )
 
@@ -22,269 +23,338 @@ const (
 	// the server if one is not specified.
 	defaultPort = "6667"
 
-	// InitialTimeout is the initial amount of time
-	// to delay before reconnecting.  Each failed
-	// reconnection doubles the timout until
-	// a connection is made successfully.
-	initialTimeout = 2 * time.Second
+	// PingTime is the interval at which keepalive
+	// pings are sent to the server.  Their replies
+	// are used to measure the lag to the server.
+	pingTime = 30 * time.Second
 
-	// PingTime is the amount of inactive time
-	// to wait before sending a ping to the server.
-	pingTime = 120 * time.Second
+	// LagWarning is the amount of lag above which
+	// a warning is written to the server window.
+	lagWarning = 10 * time.Second
 
 	// NickServer is the nick name of the nick server.
 	nickServer = "NickServ"
 )
 
 var (
-	nick       = flag.String("n", username(), "nickname")
-	full       = flag.String("f", name(), "full name")
-	pass       = flag.String("p", "", "password")
 	debug      = flag.Bool("d", false, "debugging")
-	util       = flag.String("u", "", "utility program")
-	join       = flag.String("j", "", "automatically join a channel")
-	ssl        = flag.Bool("ssl", false, "use SSL to connect to the server")
-	trustSsl   = flag.Bool("trust", false, "don't verify server's SSL certificate")
-	bridgeNick = flag.String("bridge", "", "nick name of a chat bridge")
+	configFile = flag.String("config", "", "configuration file (default $HOME/lib/velour or $XDG_CONFIG_HOME/velour/config)")
+	socket     = flag.String("socket", ctlPath(), "control socket, or empty for none")
+	plumbURLs  = flag.Bool("plumb", false, "open the irc:// URLs plumbed to the irc port")
 )
 
-var (
-	// client is the IRC client connection.
-	client *irc.Client
+// Options are the settings of a session, given by
+// flags and by the session's network profile.
+type options struct {
+	nick, altNicks, regain, full, pass string
+	util, notify, join, watch, logDir  string
+	files                              string
+	ssl, trust                         bool
+	history, scrollback                int
+	bridges                            bridgeList
+
+	// SaslUser and saslPass are the credentials for
+	// SASL authentication, given by the sasl setting.
+	saslUser, saslPass string
+
+	// Highlights are the words, other than our nick,
+	// that highlight a message that contains them.
+	highlights []string
+}
 
-	// Server is the server's address.
-	server = ""
+// Opts are the options given on the command line,
+// on which the options of each session are based.
+var opts = options{
+	nick:       username(),
+	full:       name(),
+	notify:     "highlight,private",
+	history:    50,
+	scrollback: 50,
+	logDir:     logPath(),
+}
 
-	// serverWin is the server win.
-	serverWin *win
+// winEvents multiplexes all win events.
+var winEvents = make(chan winEvent)
 
-	// winEvents multiplexes all win events.
-	winEvents = make(chan winEvent)
+func init() {
+	opts.flags(flag.CommandLine)
+}
 
-	// Quitting is set to true if the user Dels
-	// the server window.
-	quitting = false
-)
+// Flags defines the flags of the options in fs,
+// with the options' current values as defaults.
+func (o *options) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.nick, "n", o.nick, "nickname")
+	fs.StringVar(&o.altNicks, "a", o.altNicks, "comma-separated alternate nicknames")
+	fs.StringVar(&o.regain, "regain", o.regain, "NickServ command, REGAIN or GHOST, to reclaim the nickname")
+	fs.StringVar(&o.full, "f", o.full, "full name")
+	fs.StringVar(&o.pass, "p", o.pass, "password")
+	fs.StringVar(&o.util, "u", o.util, "utility program run in the background on the events given by -notify")
+	fs.StringVar(&o.notify, "notify", o.notify, "comma-separated events on which to run the utility program: highlight, private, watch, or a channel for its every message")
+	fs.StringVar(&o.join, "j", o.join, "comma-separated channels to join on every connection, each optionally followed by :key")
+	fs.BoolVar(&o.ssl, "ssl", o.ssl, "use SSL to connect to the server")
+	fs.BoolVar(&o.trust, "trust", o.trust, "don't verify server's SSL certificate")
+	fs.StringVar(&o.watch, "w", o.watch, "comma-separated nicknames to watch")
+	fs.IntVar(&o.history, "history", o.history, "number of messages of history to fetch for new chat windows")
+	fs.IntVar(&o.scrollback, "scrollback", o.scrollback, "number of logged messages to show in new chat windows")
+	fs.StringVar(&o.logDir, "logdir", o.logDir, "directory of chat logs, or empty to not log")
+	fs.StringVar(&o.files, "files", o.files, "directory of the file tree through which other programs read and post to windows, or empty for none")
+	fs.Var(&o.bridges, "bridge", "nick name of a chat bridge, with an optional format: nick[=<>|[]|:|regexp]; may be repeated")
+}
 
-var wins = map[string]*win{}
+// Copy returns a copy of the options
+// that shares no slices with them.
+func (o *options) copy() *options {
+	c := *o
+	c.bridges = append(bridgeList(nil), o.bridges...)
+	c.highlights = append([]string(nil), o.highlights...)
+	return &c
+}
 
-func getWin(target string) *win {
-	key := strings.ToLower(target)
-	w, ok := wins[key]
-	if !ok {
-		w = newWin(target)
-		wins[key] = w
+// A bridgeList is a flag.Value
+// for repeated -bridge flags.
+type bridgeList []irc.Bridge
+
+func (l *bridgeList) String() string {
+	var nicks []string
+	for _, b := range *l {
+		nicks = append(nicks, b.Nick)
 	}
-	return w
+	return strings.Join(nicks, ",")
+}
+
+func (l *bridgeList) Set(s string) error {
+	b, err := irc.ParseBridge(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, b)
+	return nil
+}
+
+// A pendingMsg is a message that we sent,
+// which will be written when echoed.
+type pendingMsg struct {
+	w    *win
+	text string
 }
 
 func main() {
 	flag.Usage = func() {
-		os.Stdout.WriteString("usage: velour [options] <server>[:<port>]\n")
+		os.Stdout.WriteString("usage: velour [options] <profile>|<server>[:<port>]|<irc-url> ...\n")
+		os.Stdout.WriteString("       velour log export|import <format> <from> <to>\n")
+		os.Stdout.WriteString("       velour ctl [-net <network>] <command> [<args>]\n")
 		flag.PrintDefaults()
-		os.Stdout.WriteString("The utility program given by the -u flag will receive a nick as the first argument, and the content of a message on standard input.\n")
+		os.Stdout.WriteString("The utility program given by the -u flag receives the nick of the latest event as its argument, the events as JSON objects on standard input, and the latest in VELOUR_* environment variables.\n")
 	}
 	flag.Parse()
-	if len(flag.Args()) != 1 {
+	if len(flag.Args()) == 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
-
-	var err error
-	var port string
-	if server, port, err = net.SplitHostPort(flag.Arg(0)); err != nil {
-		port = defaultPort
-		server = flag.Arg(0)
+	if flag.Arg(0) == "log" {
+		os.Exit(logCmd(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "ctl" {
+		os.Exit(ctlCmd(flag.Args()[1:]))
 	}
 
-	serverWin = newWin("")
-	if !*debug {
-		defer func() {
-			serverWin.del()
-			for _, win := range wins {
-				win.del()
-			}
-		}()
+	if configName = *configFile; configName == "" {
+		configName = configPath()
 	}
-	serverWin.Fprintf("tag", "Chat ")
-	// Set Dump handling for the server window.
-	if wd, err := os.Getwd(); err != nil {
-		log.Println("Failed to set dump working directory: " + err.Error())
-	} else {
-		args := make([]string, 0, len(os.Args))
-		for _, arg := range os.Args {
-			args = append(args, quote(arg))
-		}
-		serverWin.Ctl("dumpdir %s", wd)
-		serverWin.Ctl("dump %s", strings.Join(args, " "))
+	profiles, err := readConfig(configName)
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	errors := 0
-	for {
-		handleConnecting(connect(server + ":" + port))
-
-		serverWin.WriteString("Connected")
-		for _, w := range wins {
-			w.WriteString("Connected")
-			if len(w.target) > 0 && w.target[0] == '#' {
-				client.Out <- irc.Msg{Cmd: irc.JOIN, Args: []string{w.target}}
-			}
+	for _, arg := range flag.Args() {
+		s, err := newSession(arg, profiles)
+		if err != nil {
+			log.Fatal(err)
 		}
-
-		begin := time.Now()
-		handleConnection()
-
-		d := time.Now().Sub(begin)
-		if d < 1*time.Minute {
-			errors++
+		sessions = append(sessions, s)
+	}
+	for _, s := range sessions {
+		s.start()
+	}
+	if *socket != "" {
+		if l, err := listenCtl(*socket); err != nil {
+			log.Println("Failed to listen on control socket: " + err.Error())
 		} else {
-			errors = 0
-		}
-
-		if quitting || errors > 4 {
-			break
+			defer l.Close()
+			go serveCtl(l)
 		}
 	}
+	if *plumbURLs {
+		go listenPlumb()
+	}
+	go runNotifications(notifications, notifyDelay, notifyInterval, runUtils)
+	handleEvents()
 }
 
-// Connect returns a channel upon which the
-// value true is sent when a connection with
-// the server is successfully established.
-func connect(addr string) <-chan bool {
-	conn := make(chan bool)
-	go func(chan<- bool) {
-		timeout := initialTimeout
-		for {
-			var err error
-			if *ssl {
-				client, err = irc.DialSSL(addr, *nick, *full, *pass, *bridgeNick, *trustSsl)
-			} else {
-				client, err = irc.Dial(addr, *nick, *full, *pass, *bridgeNick)
-			}
-			if err == nil {
-				conn <- true
-				return
-			}
-			serverWin.WriteString("Failed to connect: " + err.Error())
-			timeout *= 2
-			<-time.After(timeout)
-		}
-	}(conn)
-	return conn
-}
+// HandleEvents handles window events, connection
+// events, and messages from the servers until the
+// clients of all sessions give up on their connections.
+func handleEvents() {
+	t := time.NewTicker(pingTime)
+	defer t.Stop()
+	wt := time.NewTicker(watchTime)
+	defer wt.Stop()
 
-// HandleConnecting handles window events while
-// attempting to connect to the server.
-func handleConnecting(conn <-chan bool) {
-	for {
+	for len(sessions) > 0 {
 		select {
-		case <-conn:
-			return
-
 		case ev := <-winEvents:
-			if ev.timeStamp {
-				continue
+			s := ev.win.s
+			switch {
+			case ev.timeStamp:
+				ev.win.printTimeStamp()
+			case ev.typingTimeout:
+				ev.win.tickTyping()
+			case s.connected:
+				s.handleWindowEvent(ev)
+			default:
+				s.handleOfflineWindowEvent(ev)
 			}
+
+		case ev := <-sessionEvents:
+			s := ev.session
 			switch {
-			case ev.C2 == 'x' || ev.C2 == 'X':
-				fs := strings.Fields(string(ev.Text))
-				if len(fs) > 0 && fs[0] == "Del" {
-					if ev.win == serverWin {
-						exit(0, "Quit")
-					}
-					ev.win.del()
+			case ev.ev != nil:
+				s.connected = ev.ev.Kind == irc.Connected
+				s.handleConnEvent(*ev.ev)
+				s.syncFiles()
+			case ev.msg != nil:
+				s.handleMsg(*ev.msg)
+				s.syncFiles()
+			case ev.err != nil:
+				if long, il := ev.err.(irc.MsgTooLong); il {
+					log.Println("Truncated", long.NTrunc, "bytes from message")
+				} else {
+					log.Println(ev.err)
 				}
+			default:
+				s.close()
+			}
+
+		case l := <-fileLines:
+			l.w.handleFileLine(l)
+
+		case text := <-plumbed:
+			openURL(text)
+
+		case r := <-ctlRequests:
+			out, err := handleCtl(r.line)
+			r.reply <- ctlReply{out, err}
 
-			case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'I':
-				// Disallow typing while not connected
-				ev.win.Addr("#%d,#%d", ev.Q0, ev.Q1)
-				ev.win.writeData([]byte{0})
-
-			case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'D':
-				ev.deleting(ev.Q0, ev.Q1)
-
-			case ev.C2 == 'l' || ev.C2 == 'L':
-				if ev.Flag&2 != 0 { // expansion
-					// The look was on highlighted text.  Instead of
-					// sending the hilighted text, send the original
-					// addresses, so that 3-clicking without draging
-					// on selected text doesn't move the cursor
-					// out of the tag.
-					ev.Q0 = ev.OrigQ0
-					ev.Q1 = ev.OrigQ1
+		case <-t.C:
+			for _, s := range sessions {
+				if s.connected {
+					s.showLag()
+					s.client.Ping()
+				}
+			}
+
+		case <-wt.C:
+			for _, s := range sessions {
+				if s.connected {
+					s.pollWatched()
 				}
-				ev.WriteEvent(ev.Event)
 			}
 		}
 	}
 }
 
-// HandleConnection handles events while
-// connected to a server.
-func handleConnection() {
-	t := time.NewTimer(pingTime)
+// HandleConnEvent handles changes to the
+// state of the connection to the server.
+func (s *session) handleConnEvent(ev irc.Event) {
+	switch ev.Kind {
+	case irc.Connected:
+		s.serverWin.WriteString("Connected")
+		for _, w := range s.wins {
+			w.WriteString("Connected")
+		}
+		if s.opts.saslUser != "" && !ev.Authenticated {
+			s.serverWin.writeMsg("=ERROR: the server did not accept SASL; not authenticated as " + s.opts.saslUser)
+		}
+		if ev.Nick != s.nick {
+			s.doNick(s.nick, ev.Nick)
+		}
+		for _, w := range s.wins {
+			// Channel history is fetched when rejoined.
+			if !strings.HasPrefix(w.target, "#") {
+				s.fetchHistory(w)
+			}
+		}
 
-	defer func() {
-		t.Stop()
-		close(client.Out)
-		serverWin.WriteString("Disconnected")
-		serverWin.Ctl("clean")
-		for _, w := range wins {
+	case irc.Disconnected:
+		if ev.Err != nil && ev.Err != io.EOF {
+			log.Println(ev.Err)
+		}
+		for l, p := range s.pending {
+			p.w.writeMsg("=not sent: " + strings.TrimRight(p.text, "\n"))
+			delete(s.pending, l)
+		}
+		s.lagging = false
+		s.serverWin.setStatus("")
+		s.serverWin.WriteString("Disconnected")
+		s.serverWin.Ctl("clean")
+		for _, w := range s.wins {
 			w.WriteString("Disconnected")
 			w.users = make(map[string]*user)
+			w.usersChanged = true
 			w.lastSpeaker = ""
+			w.missed = true
+			w.firstLive = time.Time{}
+			w.resetTyping()
 			w.Ctl("clean")
 		}
-		for err := range client.Errors {
-			if err != io.EOF {
-				log.Println(err)
-			}
-		}
-	}()
 
-	if *join != "" {
-		client.Out <- irc.Msg{Cmd: irc.JOIN, Args: []string{*join}}
-		*join = ""
+	case irc.Reconnecting:
+		if ev.Err != nil {
+			s.serverWin.WriteString("Failed to connect: " + ev.Err.Error())
+		}
+		s.serverWin.WriteString("Reconnecting in " + ev.Delay.Round(time.Second).String() +
+			" (attempt " + strconv.Itoa(ev.Attempt) + ")")
 	}
+}
 
-	for {
-		select {
-		case ev := <-winEvents:
-			if ev.timeStamp {
-				ev.win.printTimeStamp()
-			} else {
-				handleWindowEvent(ev)
-			}
-
-		case msg, ok := <-client.In:
-			if !ok { // disconnect
+// HandleOfflineWindowEvent handles window
+// events while not connected to the server.
+func (s *session) handleOfflineWindowEvent(ev winEvent) {
+	switch {
+	case ev.C2 == 'x' || ev.C2 == 'X':
+		fs := strings.Fields(string(ev.Text))
+		if len(fs) > 0 && fs[0] == "Del" {
+			if ev.win == s.serverWin {
+				s.quit("")
 				return
 			}
-			t.Reset(pingTime)
-			handleMsg(msg)
+			ev.win.del()
+		}
 
-		case <-t.C:
-			client.Out <- irc.Msg{Cmd: irc.PING, Args: []string{client.Server}}
-			t = time.NewTimer(pingTime)
-
-		case err, ok := <-client.Errors:
-			if ok {
-				long, il := err.(irc.MsgTooLong)
-				if !il && err != io.EOF {
-					log.Println(err)
-					return
-				}
-				if il {
-					log.Println("Truncated", long.NTrunc, "bytes from message")
-				}
-			}
+	case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'I':
+		// Disallow typing while not connected
+		ev.win.Addr("#%d,#%d", ev.Q0, ev.Q1)
+		ev.win.writeData([]byte{0})
+
+	case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'D':
+		ev.deleting(ev.Q0, ev.Q1)
+
+	case ev.C2 == 'l' || ev.C2 == 'L':
+		if ev.Flag&2 != 0 { // expansion
+			// The look was on highlighted text.  Instead of
+			// sending the hilighted text, send the original
+			// addresses, so that 3-clicking without draging
+			// on selected text doesn't move the cursor
+			// out of the tag.
+			ev.Q0 = ev.OrigQ0
+			ev.Q1 = ev.OrigQ1
 		}
+		ev.WriteEvent(ev.Event)
 	}
 }
 
 // HandleWindowEvent handles events from
 // any of the acme wins.
-func handleWindowEvent(ev winEvent) {
+func (s *session) handleWindowEvent(ev winEvent) {
 	if *debug {
 		log.Printf("%#v\nText=[%s]\n\n", *ev.Event, string(ev.Text))
 	}
@@ -297,7 +367,7 @@ func handleWindowEvent(ev winEvent) {
 			return
 		}
 		fs := strings.Fields(text)
-		if len(fs) > 0 && handleExecute(ev, fs[0], fs[1:]) {
+		if len(fs) > 0 && s.handleExecute(ev, fs[0], fs[1:]) {
 			return
 		}
 		if ev.Flag&1 != 0 { // acme recognized built-in command
@@ -311,9 +381,11 @@ func handleWindowEvent(ev winEvent) {
 		ev.writeToPrompt(text)
 
 	case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'I':
+		ev.shift(ev.Q0, ev.Q1-ev.Q0, nil)
 		ev.typing(ev.Q0, ev.Q1)
 
 	case (ev.C1 == 'M' || ev.C1 == 'K') && ev.C2 == 'D':
+		ev.shift(ev.Q1, ev.Q0-ev.Q1, nil)
 		ev.deleting(ev.Q0, ev.Q1)
 
 	case ev.C2 == 'l' || ev.C2 == 'L':
@@ -344,44 +416,80 @@ func extractName(w *win, text string) (string, bool) {
 }
 
 // HandleExecute handles acme execte commands.
-func handleExecute(ev winEvent, cmd string, args []string) bool {
+func (s *session) handleExecute(ev winEvent, cmd string, args []string) bool {
 	switch cmd {
 	case "Debug":
 		*debug = !*debug
 
 	case "Del":
 		t := ev.target
-		if ev.win == serverWin {
-			quitting = true
-			client.Out <- irc.Msg{Cmd: irc.QUIT}
+		if ev.win == s.serverWin {
+			s.quit("")
 		} else if t != "" && t[0] == '#' { // channel
-			client.Out <- irc.Msg{Cmd: irc.PART, Args: []string{t}}
+			s.client.Out <- irc.Msg{Cmd: irc.PART, Args: []string{t}}
 		} else { // private chat
 			ev.win.del()
 		}
 
 	case "Chat":
-		if len(args) != 1 {
+		if len(args) < 1 || len(args) > 2 {
 			break
 		}
-		if args[0][0] == '#' {
-			client.Out <- irc.Msg{Cmd: irc.JOIN, Args: []string{args[0]}}
-		} else { // private message
-			getWin(args[0])
-		}
+		s.chat(args)
+
+	case "Autojoin":
+		s.doAutojoin(args)
+
+	case "Search":
+		s.doSearch(args)
 
 	case "Nick":
 		if len(args) != 1 {
 			break
 		}
-		client.Out <- irc.Msg{Cmd: irc.NICK, Args: []string{args[0]}}
+		s.client.Out <- irc.Msg{Cmd: irc.NICK, Args: []string{args[0]}}
 
 	case "Who":
 		if ev.target[0] != '#' {
 			break
 		}
 		ev.win.who = []string{}
-		client.Out <- irc.Msg{Cmd: irc.WHO, Args: []string{ev.target}}
+		s.client.Out <- irc.Msg{Cmd: irc.WHO, Args: []string{ev.target}}
+
+	case "Reply":
+		l, ok := s.selectedLine(ev.win)
+		if !ok {
+			break
+		}
+		ev.win.replyTo = l.id
+		if len(args) == 0 {
+			ev.win.replyWho = l.who
+			ev.win.updateStatus()
+			break
+		}
+		ev.win.Addr("%s,%s", beforePrompt, afterPrompt)
+		ev.win.send(strings.Join(args, " ") + "\n")
+
+	case "React":
+		l, ok := s.selectedLine(ev.win)
+		if !ok || len(args) != 1 {
+			break
+		}
+		s.client.Out <- irc.React(ev.target, l.id, args[0])
+		if !s.client.HasCap(irc.EchoCap) {
+			ev.win.react(l.id, s.nick, args[0])
+		}
+
+	case "Redact":
+		l, ok := s.selectedLine(ev.win)
+		if !ok {
+			break
+		}
+		if !s.client.HasCap(irc.RedactCap) {
+			ev.win.writeMsg("=ERROR: the server does not support redaction")
+			break
+		}
+		s.client.Out <- irc.Redact(ev.target, l.id, strings.Join(args, " "))
 
 	default:
 		return false
@@ -390,29 +498,48 @@ func handleExecute(ev winEvent, cmd string, args []string) bool {
 	return true
 }
 
+// SelectedLine returns the message selected in the
+// window for the Reply, React, and Redact commands.
+func (s *session) selectedLine(w *win) (*line, bool) {
+	if w == s.serverWin {
+		return nil, false
+	}
+	l, ok := w.selectedLine()
+	if !ok {
+		w.writeMsg("=ERROR: select a message to reply to")
+	}
+	return l, ok
+}
+
 // HandleMsg handles IRC messages from the server.
-func handleMsg(msg irc.Msg) {
+func (s *session) handleMsg(msg irc.Msg) {
+	if l := msg.Tags["label"]; l != "" && msg.Cmd != irc.BATCH {
+		if s.doLabeled(l, msg) {
+			return
+		}
+	}
+
 	switch msg.Cmd {
 	case irc.ERROR:
-		if !quitting {
-			exit(1, "Received error: "+msg.Raw)
+		if !s.quitting {
+			s.serverWin.WriteString("Received error: " + msg.Raw)
 		}
 
 	case irc.PING:
-		client.Out <- irc.Msg{Cmd: irc.PONG, Args: msg.Args}
+		s.client.Out <- irc.Msg{Cmd: irc.PONG, Args: msg.Args}
 
 	case irc.PONG:
-		// OK, ignore
+		s.showLag()
 
 	case irc.ERR_NOSUCHNICK:
-		doNoSuchNick(msg.Args[1], lastArg(msg))
+		s.doNoSuchNick(msg.Args[1], lastArg(msg))
 
 	case irc.ERR_NOSUCHCHANNEL:
-		doNoSuchChannel(msg.Args[1])
+		s.doNoSuchChannel(msg.Args[1])
 
 	case irc.ERR_NOTONCHANNEL:
 		cmd := irc.CmdNames[msg.Cmd]
-		serverWin.WriteString("(" + cmd + ") " + msg.Raw)
+		s.serverWin.WriteString("(" + cmd + ") " + msg.Raw)
 		// We aren't in this channel,
 		// but if we somehow managed to open a window for it
 		// (for example by checking its TOPIC),
@@ -422,93 +549,210 @@ func handleMsg(msg irc.Msg) {
 		// However, irc.freenode.net sends <username> <channel>,
 		// so if there are multiple args and the 0th arg is the user name,
 		// close the second argument.
-		if len(msg.Args) > 1 && msg.Args[0] == *nick {
-			if w, ok := wins[strings.ToLower(msg.Args[1])]; ok {
+		if len(msg.Args) > 1 && msg.Args[0] == s.nick {
+			if w, ok := s.wins[strings.ToLower(msg.Args[1])]; ok {
 				w.del()
 			}
-		} else if w, ok := wins[strings.ToLower(msg.Args[0])]; ok {
+		} else if w, ok := s.wins[strings.ToLower(msg.Args[0])]; ok {
 			w.del()
 		}
 
 	case irc.RPL_MOTD:
-		serverWin.WriteString(lastArg(msg))
+		s.serverWin.WriteString(lastArg(msg))
+
+	case irc.RPL_ENDOFMOTD, irc.ERR_NOMOTD:
+		s.serverWin.WriteString(lastArg(msg))
+		s.startWatching()
+
+	case irc.RPL_ISON:
+		s.doIsOn(lastArg(msg))
+
+	case irc.RPL_MONONLINE:
+		s.doMonitor(lastArg(msg), true)
+
+	case irc.RPL_MONOFFLINE:
+		s.doMonitor(lastArg(msg), false)
+
+	case irc.ERR_MONLISTFULL:
+		if len(msg.Args) >= 3 {
+			s.doMonListFull(msg.Args[1], msg.Args[2])
+		}
 
 	case irc.RPL_NAMREPLY:
-		doNamReply(msg.Args[len(msg.Args)-2], lastArg(msg))
+		s.doNamReply(msg.Args[len(msg.Args)-2], lastArg(msg))
 
 	case irc.RPL_TOPIC:
-		doTopic(msg.Args[1], "", lastArg(msg))
+		s.doTopic(msg.Args[1], "", lastArg(msg))
 
 	case irc.KICK:
-		doKick(msg.Args[0], msg.Origin, msg.Args[1])
+		s.doKick(msg.Args[0], msg.Origin, msg.Args[1])
 
 	case irc.TOPIC:
-		doTopic(msg.Args[0], msg.Origin, lastArg(msg))
+		s.doTopic(msg.Args[0], msg.Origin, lastArg(msg))
 
 	case irc.MODE:
 		if len(msg.Args) < 3 { // I dunno what this is, but I bet it's valid.
 			cmd := irc.CmdNames[msg.Cmd]
-			serverWin.WriteString("(" + cmd + ") " + msg.Raw)
+			s.serverWin.WriteString("(" + cmd + ") " + msg.Raw)
 			break
 		}
-		doMode(msg.Args[0], msg.Args[1], msg.Args[2])
+		s.doMode(msg.Args[0], msg.Args[1], msg.Args[2])
+
+	case irc.BATCH:
+		if msg.Batch == nil {
+			break
+		}
+		if l := msg.Tags["label"]; l != "" && msg.Batch.Type != "labeled-response" {
+			// The echo of a labeled multi-line message.
+			delete(s.pending, l)
+		} else if l != "" {
+			// The response to a labeled message.
+			for i := range msg.Batch.Msgs {
+				m := &msg.Batch.Msgs[i]
+				if m.Tags == nil {
+					m.Tags = map[string]string{}
+				}
+				m.Tags["label"] = l
+			}
+		}
+		s.doBatch(msg)
+
+	case irc.ACK:
+		// OK, ignore
+
+	case irc.ERR_CANNOTSENDTOCHAN:
+		s.doCannotSend(msg.Args[1], lastArg(msg), "")
 
 	case irc.JOIN:
-		doJoin(msg.Args[0], msg.Origin)
+		account := ""
+		if len(msg.Args) > 2 { // extended-join
+			account = msg.Args[1]
+		}
+		s.doJoin(msg.Args[0], msg.Origin, account)
+
+	case irc.AWAY:
+		if len(msg.Args) == 0 {
+			s.doAway(msg.Origin, false, "")
+		} else {
+			s.doAway(msg.Origin, true, lastArg(msg))
+		}
+
+	case irc.ACCOUNT:
+		s.doAccount(msg.Origin, lastArg(msg))
 
 	case irc.PART:
-		doPart(msg.Args[0], msg.Origin)
+		s.doPart(msg.Args[0], msg.Origin)
 
 	case irc.QUIT:
-		doQuit(msg.Origin, lastArg(msg))
+		s.doQuit(msg.Origin, lastArg(msg))
 
 	case irc.NOTICE:
-		doNotice(msg.Args[0], msg.Origin, lastArg(msg))
+		s.doNotice(msg.Args[0], sender(msg), lastArg(msg), msg.Time, msg.Tags)
 
 	case irc.PRIVMSG:
-		doPrivMsg(msg.Args[0], msg.Origin, msg.Args[1])
+		s.doPrivMsg(msg.Args[0], sender(msg), msg.Args[1], msg.Time, msg.Tags)
+
+	case irc.TAGMSG:
+		if len(msg.Args) == 0 {
+			break
+		}
+		if r := msg.Tags[irc.ReactTag]; r != "" {
+			s.doReact(msg.Args[0], msg.Origin, msg.Tags[irc.ReplyTag], r)
+		}
+		if t := msg.Tags[irc.TypingTag]; t != "" {
+			s.doTyping(msg.Args[0], msg.Origin, t)
+		}
+
+	case irc.REDACT:
+		if len(msg.Args) > 1 {
+			reason := ""
+			if len(msg.Args) > 2 {
+				reason = lastArg(msg)
+			}
+			s.doRedact(msg.Args[0], msg.Origin, msg.Args[1], reason)
+		}
 
 	case irc.NICK:
-		doNick(msg.Origin, msg.Args[0])
+		s.doNick(msg.Origin, msg.Args[0])
 
 	case irc.RPL_WHOREPLY:
-		doWhoReply(msg.Args[1], msg.Args[2:])
+		s.doWhoReply(msg.Args[1], msg.Args[2:])
 
 	case irc.RPL_ENDOFWHO:
-		doEndOfWho(msg.Args[1])
+		s.doEndOfWho(msg.Args[1])
 
 	default:
 		cmd := irc.CmdNames[msg.Cmd]
-		serverWin.WriteString("(" + cmd + ") " + msg.Raw)
+		s.serverWin.WriteString("(" + cmd + ") " + msg.Raw)
 	}
 }
 
-func doNoSuchNick(ch, msg string) {
-	getWin(ch).writeMsg("=ERROR: " + ch + ":" + msg)
+func (s *session) doNoSuchNick(ch, msg string) {
+	s.getWin(ch).writeMsg("=ERROR: " + ch + ":" + msg)
 }
 
-func doNoSuchChannel(ch string) {
+// DoLabeled handles a reply to the pending message
+// with the given label. It returns true if the reply
+// was handled, and need not be handled further.
+// Error replies are written to the message's window.
+func (s *session) doLabeled(label string, msg irc.Msg) bool {
+	p, ok := s.pending[label]
+	if !ok {
+		return false
+	}
+	delete(s.pending, label)
+	if len(msg.Cmd) == 3 && (msg.Cmd[0] == '4' || msg.Cmd[0] == '5') {
+		s.doCannotSend(p.w.target, lastArg(msg), p.text)
+		return true
+	}
+	return false
+}
+
+// DoCannotSend reports in the target's window
+// that the text could not be sent.
+func (s *session) doCannotSend(target, why, text string) {
+	w, ok := s.wins[strings.ToLower(target)]
+	if !ok {
+		w = s.serverWin
+	}
+	m := "=ERROR: " + why
+	if text = strings.TrimRight(text, "\n"); text != "" {
+		m += ": " + text
+	}
+	w.writeMsg(m)
+}
+
+func (s *session) doNoSuchChannel(ch string) {
 	// Must have PARTed a channel that is not JOINed.
-	getWin(ch).del()
+	if w, ok := s.wins[strings.ToLower(ch)]; ok {
+		w.del()
+	}
 }
 
-func doNamReply(ch string, names string) {
+// DoNamReply adds the names of a channel's member list to
+// its users. They are not joins, so none is written or logged.
+func (s *session) doNamReply(ch string, names string) {
+	w := s.getWin(ch)
 	for _, n := range strings.Fields(names) {
 		n = strings.TrimLeft(n, "@+")
-		if n != *nick {
-			doJoin(ch, n)
+		if _, ok := w.users[n]; ok || n == s.nick {
+			continue
 		}
+		w.usersChanged = true
+		w.users[n] = &user{nick: n, origNick: n, changedAt: time.Now()}
 	}
 }
 
-func doKick(ch, op, who string) {
-	w := getWin(ch)
+func (s *session) doKick(ch, op, who string) {
+	w := s.getWin(ch)
 	w.writeMsg("=" + op + " kicked " + who)
 	delete(w.users, who)
+	w.usersChanged = true
 }
 
-func doTopic(ch, who, what string) {
-	w := getWin(ch)
+func (s *session) doTopic(ch, who, what string) {
+	w := s.getWin(ch)
+	w.setTopic(what)
 	if who == "" {
 		w.writeMsg("=topic: " + what)
 	} else {
@@ -516,121 +760,486 @@ func doTopic(ch, who, what string) {
 	}
 }
 
-func doMode(ch, mode, who string) {
+func (s *session) doMode(ch, mode, who string) {
 	if len(ch) == 0 || ch[0] != '#' {
 		return
 	}
-	w := getWin(ch)
+	w := s.getWin(ch)
 	w.writeMsg("=" + who + " mode " + mode)
 }
 
-func doJoin(ch, who string) {
-	w := getWin(ch)
-	w.writeMsg("+" + who)
-	if who != *nick {
+// DoJoin handles a user joining a channel.
+// The account is the user's services account name
+// given by extended-join, "*" if the user is not
+// logged in, or the empty string if it is unknown.
+func (s *session) doJoin(ch, who, account string) {
+	w := s.getWin(ch)
+	if who == s.nick && w.missed {
+		s.fetchHistory(w)
+	}
+	if account == "*" {
+		account = ""
+	}
+	if account != "" {
+		w.writeMsg("+" + who + " [" + account + "]")
+	} else {
+		w.writeMsg("+" + who)
+	}
+	if who != s.nick {
+		w.usersChanged = true
 		w.users[who] = &user{
 			nick:      who,
 			origNick:  who,
 			changedAt: time.Now(),
+			account:   account,
+		}
+	}
+}
+
+// DoAway handles an away-notify change to
+// a user's away status.
+func (s *session) doAway(who string, away bool, msg string) {
+	for _, w := range s.wins {
+		u, ok := w.users[who]
+		if !ok || u.away == away && u.awayMsg == msg {
+			continue
+		}
+		u.away = away
+		u.awayMsg = msg
+		switch {
+		case !away:
+			w.writeMsg("=" + who + " is back")
+		case msg != "":
+			w.writeMsg("=" + who + " is away: " + msg)
+		default:
+			w.writeMsg("=" + who + " is away")
+		}
+	}
+}
+
+// DoAccount handles an account-notify change
+// to a user's services account.
+func (s *session) doAccount(who, account string) {
+	if account == "*" {
+		account = ""
+	}
+	for _, w := range s.wins {
+		if u, ok := w.users[who]; ok {
+			u.account = account
 		}
 	}
 }
 
-func doPart(ch, who string) {
-	w, ok := wins[strings.ToLower(ch)]
+func (s *session) doPart(ch, who string) {
+	w, ok := s.wins[strings.ToLower(ch)]
 	if !ok {
 		return
 	}
-	if who == *nick {
+	if who == s.nick {
 		w.del()
 	} else {
 		w.writeMsg("-" + who)
 		delete(w.users, who)
+		w.usersChanged = true
 	}
 }
 
-func doQuit(who, txt string) {
-	for _, w := range wins {
+func (s *session) doQuit(who, txt string) {
+	for _, w := range s.wins {
 		if _, ok := w.users[who]; !ok {
 			continue
 		}
 		delete(w.users, who)
-		s := "-" + who + " quit"
+		w.usersChanged = true
+		m := "-" + who + " quit"
 		if txt != "" {
-			s += ": " + txt
+			m += ": " + txt
 		}
-		w.writeMsg(s)
+		w.writeMsg(m)
 	}
 }
 
-func doPrivMsg(ch, who, text string) {
-	if ch == *nick {
+func (s *session) doPrivMsg(ch, who, text string, t time.Time, tags map[string]string) {
+	if ch == s.nick {
 		ch = who
 	}
 
-	if *util != "" {
-		cmd := exec.Command(*util, who)
-		cmd.Stdin = strings.NewReader(text)
-		if err := cmd.Run(); err != nil {
-			log.Printf("Error running util (%s): %v\n", *util, err)
-		}
-	}
-
 	// If this is NickServ, and there is no NickServ window open
 	// then just dump its messages to the server window.
 	l := strings.ToLower(who)
-	if _, ok := wins[l]; !ok && l == strings.ToLower(nickServer) {
-		serverWin.writePrivMsg(who, text)
+	if _, ok := s.wins[l]; !ok && l == strings.ToLower(nickServer) {
+		s.serverWin.writePrivMsg(who, text, t, tags)
 		return
 	}
 
-	getWin(ch).writePrivMsg(who, text)
+	w := s.getWin(ch)
+	if w.firstLive.IsZero() {
+		w.firstLive = t
+	}
+	w.stopTyping(who)
+	w.writePrivMsg(who, text, t, tags)
+	s.notifyMsg(w, who, text, t)
 }
 
-func doNotice(ch, who, text string) {
-	doPrivMsg(ch, who, text)
+func (s *session) doNotice(ch, who, text string, t time.Time, tags map[string]string) {
+	s.doPrivMsg(ch, who, text, t, tags)
 }
 
-func doNick(prev, cur string) {
-	if prev == *nick {
-		*nick = cur
-		for _, w := range wins {
+// DoReact handles a reaction by who to the message
+// with the msgid, if it is in an open window.
+func (s *session) doReact(ch, who, id, text string) {
+	if ch == s.nick {
+		ch = who
+	}
+	if w, ok := s.wins[strings.ToLower(ch)]; ok {
+		w.react(id, who, text)
+	}
+}
+
+// DoRedact handles the redaction by who of the
+// message with the msgid, if it is in an open window.
+func (s *session) doRedact(ch, who, id, reason string) {
+	if ch == s.nick {
+		ch = who
+	}
+	if w, ok := s.wins[strings.ToLower(ch)]; ok {
+		w.redact(id, who, reason)
+	}
+}
+
+func (s *session) doNick(prev, cur string) {
+	if prev == s.nick {
+		s.nick = cur
+		s.serverWin.writeMsg("~" + prev + " → " + cur)
+		for _, w := range s.wins {
 			w.writeMsg("~" + prev + " → " + cur)
 		}
 		return
 	}
 
-	for _, w := range wins {
+	for _, w := range s.wins {
 		if u, ok := w.users[prev]; ok {
 			delete(w.users, prev)
 			u.changedAt = time.Now()
 			u.nick = cur
 			w.users[cur] = u
+			w.usersChanged = true
 			w.writeMsg("~" + prev + " → " + cur)
 		}
 	}
 }
 
-func doWhoReply(ch string, info []string) {
-	w := getWin(ch)
-	s := info[3]
+func (s *session) doWhoReply(ch string, info []string) {
+	w := s.getWin(ch)
+	n := info[3]
 	if strings.IndexRune(info[4], '+') >= 0 {
-		s = "+" + s
+		n = "+" + n
 	}
 	if strings.IndexRune(info[4], '@') >= 0 {
-		s = "@" + s
+		n = "@" + n
 	}
-	w.who = append(w.who, s)
-	serverWin.WriteString(ch + " " + s + " " + info[0] + "@" + info[1])
+	w.who = append(w.who, n)
+	s.serverWin.WriteString(ch + " " + n + " " + info[0] + "@" + info[1])
 }
 
-func doEndOfWho(ch string) {
-	w := getWin(ch)
+func (s *session) doEndOfWho(ch string) {
+	w := s.getWin(ch)
 	sort.Strings(w.who)
 	w.writeMsg("[" + strings.Join(w.who, "] [") + "]")
 	w.who = w.who[:0]
 }
 
+// ShowLag displays the current lag in the server window's tag,
+// and warns in the server window when it crosses lagWarning.
+func (s *session) showLag() {
+	cur, avg := s.client.Lag()
+	if cur == 0 {
+		return
+	}
+	s.serverWin.setStatus("lag " + fmtLag(cur) + " (avg " + fmtLag(avg) + ")")
+	switch {
+	case !s.lagging && cur >= lagWarning:
+		s.lagging = true
+		s.serverWin.WriteString("Lag to server is " + fmtLag(cur))
+	case s.lagging && cur < lagWarning:
+		s.lagging = false
+		s.serverWin.WriteString("Lag to server is back to " + fmtLag(cur))
+	}
+}
+
+func fmtLag(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 2, 64) + "s"
+}
+
+// SplitList returns the non-empty elements
+// of a comma-separated list.
+func splitList(s string) []string {
+	var l []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// DoBatch handles a complete batch of messages
+// opened by the BATCH message m.
+// The messages of batches of unknown types
+// are handled individually.
+func (s *session) doBatch(m irc.Msg) {
+	b := m.Batch
+	switch b.Type {
+	case "chathistory":
+		s.doHistory(b)
+
+	case "netsplit":
+		s.doNetSplit(b)
+
+	case "netjoin":
+		s.doNetJoin(b)
+
+	case irc.MultilineCap:
+		s.doMultiline(m)
+
+	default:
+		for _, m := range b.Msgs {
+			s.handleMsg(m)
+		}
+	}
+}
+
+// Chat joins the channel, given with an optional key,
+// or opens a chat window with the user, showing the
+// window if it is already open.
+func (s *session) chat(args []string) {
+	if w, ok := s.wins[strings.ToLower(args[0])]; ok {
+		w.Ctl("show")
+		if !isChannel(args[0]) || len(w.users) > 0 {
+			return
+		}
+	}
+	if isChannel(args[0]) {
+		s.client.Out <- irc.Msg{Cmd: irc.JOIN, Args: args}
+	} else { // private message
+		s.getWin(args[0])
+	}
+}
+
+// DoNetSplit handles a netsplit batch of QUITs,
+// writing a single line to each channel window
+// listing the users that quit.
+func (s *session) doNetSplit(b *irc.Batch) {
+	for _, w := range s.wins {
+		var who []string
+		for _, m := range b.Msgs {
+			if _, ok := w.users[m.Origin]; ok && m.Cmd == irc.QUIT {
+				delete(w.users, m.Origin)
+				w.usersChanged = true
+				who = append(who, m.Origin)
+			}
+		}
+		if len(who) > 0 {
+			w.writeMsg("-netsplit " + strings.Join(b.Params, " ") + ": " + strings.Join(who, " "))
+		}
+	}
+}
+
+// DoNetJoin handles a netjoin batch of JOINs,
+// writing a single line to each channel window
+// listing the users that joined.
+func (s *session) doNetJoin(b *irc.Batch) {
+	var chans []string
+	joins := map[string][]string{}
+	for _, m := range b.Msgs {
+		if m.Cmd != irc.JOIN || len(m.Args) == 0 {
+			continue
+		}
+		ch := strings.ToLower(m.Args[0])
+		if _, ok := joins[ch]; !ok {
+			chans = append(chans, m.Args[0])
+		}
+		joins[ch] = append(joins[ch], m.Origin)
+	}
+	for _, ch := range chans {
+		w := s.getWin(ch)
+		who := joins[strings.ToLower(ch)]
+		for _, n := range who {
+			w.users[n] = &user{nick: n, origNick: n, changedAt: time.Now()}
+		}
+		w.usersChanged = true
+		w.writeMsg("+netjoin " + strings.Join(b.Params, " ") + ": " + strings.Join(who, " "))
+	}
+}
+
+// DoMultiline handles a multi-line message,
+// writing it as a single message.
+func (s *session) doMultiline(m irc.Msg) {
+	if len(m.Batch.Params) == 0 || len(m.Batch.Msgs) == 0 {
+		return
+	}
+	m = multilineMsg(m)
+	s.doPrivMsg(m.Args[0], sender(m), m.Args[1], m.Time, m.Tags)
+}
+
+// MultilineMsg returns the message with the text of the
+// multi-line batch opened by m, with the tags of both the
+// opening BATCH message and the batch's first message.
+func multilineMsg(m irc.Msg) irc.Msg {
+	first := m.Batch.Msgs[0]
+	first.Args = []string{m.Batch.Params[0], irc.MultilineText(m.Batch)}
+	tags := map[string]string{}
+	for k, v := range first.Tags {
+		tags[k] = v
+	}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+	first.Tags = tags
+	if _, ok := m.Tags["time"]; ok {
+		first.Time = m.Time
+	}
+	if m.Origin != "" {
+		first.Origin = m.Origin
+	}
+	return first
+}
+
+// DoAutojoin adds channels, or the joined channels if
+// none are given, to those joined on every connection,
+// saving them to the network's profile in the
+// configuration file.
+func (s *session) doAutojoin(args []string) {
+	var chs []irc.Channel
+	for _, a := range args {
+		chs = append(chs, irc.ParseChannels(a)...)
+	}
+	if len(chs) == 0 {
+		chs = s.client.Channels()
+	}
+	added := s.client.Autojoin(chs...)
+	if len(added) == 0 {
+		return
+	}
+	var names []string
+	for _, ch := range added {
+		names = append(names, ch.String())
+	}
+	s.serverWin.writeMsg("=autojoin " + strings.Join(names, " "))
+	if s.network == "" {
+		s.serverWin.writeMsg("=not saved: no network profile")
+		return
+	}
+	if err := addJoins(configName, s.network, added); err != nil {
+		s.serverWin.writeMsg("=ERROR: " + err.Error())
+	}
+}
+
+// DoSearch opens a window listing the entries of the
+// network's logs that match a regular expression, each
+// preceded by the address of its file and line.
+func (s *session) doSearch(args []string) {
+	if len(args) == 0 {
+		return
+	}
+	if s.opts.logDir == "" {
+		s.serverWin.writeMsg("=ERROR: logging is disabled")
+		return
+	}
+	re, err := regexp.Compile(strings.Join(args, " "))
+	if err != nil {
+		s.serverWin.writeMsg("=ERROR: " + err.Error())
+		return
+	}
+	aw, err := acme.New()
+	if err != nil {
+		s.serverWin.writeMsg("=ERROR: " + err.Error())
+		return
+	}
+	dir := s.networkLogDir()
+	aw.Name("%s/+Search", dir)
+	go func() {
+		defer aw.CloseFiles()
+		n, err := searchLogs(bodyWriter{aw}, dir, re)
+		if err != nil {
+			aw.Fprintf("body", "%s\n", err)
+		}
+		aw.Fprintf("body", "%d matches for %s\n", n, re)
+		aw.Addr("#0")
+		aw.Ctl("dot=addr")
+		aw.Ctl("show")
+		aw.Ctl("clean")
+	}()
+}
+
+// FetchHistory requests the history of a window's target:
+// the latest messages for a new window, or the messages
+// after the last message if the window missed messages
+// while disconnected.
+func (s *session) fetchHistory(w *win) {
+	if w.target == "" || s.opts.history <= 0 || !s.client.HasCap(irc.HistoryCap) {
+		return
+	}
+	n := s.opts.history
+	if v, ok := s.client.ISupport("CHATHISTORY"); ok {
+		if max, err := strconv.Atoi(v); err == nil && max > 0 && max < n {
+			n = max
+		}
+	}
+	if w.missed {
+		s.client.Out <- irc.HistoryAfter(w.target, w.lastTime, n)
+	} else {
+		s.client.Out <- irc.HistoryLatest(w.target, n)
+	}
+	w.missed = false
+}
+
+// DoHistory writes the messages of a chathistory batch
+// to the window of its target. Messages that were
+// also received live are not written.
+func (s *session) doHistory(b *irc.Batch) {
+	if len(b.Params) == 0 {
+		return
+	}
+	w, ok := s.wins[strings.ToLower(b.Params[0])]
+	if !ok {
+		return
+	}
+	w.replaying = true
+	defer func() { w.replaying = false }()
+	n := 0
+	for _, m := range b.Msgs {
+		if m.Cmd == irc.BATCH && m.Batch != nil && m.Batch.Type == irc.MultilineCap &&
+			len(m.Batch.Params) > 0 && len(m.Batch.Msgs) > 0 {
+			m = multilineMsg(m)
+		} else if m.Cmd != irc.PRIVMSG && m.Cmd != irc.NOTICE || len(m.Args) < 2 {
+			continue
+		}
+		if !w.firstLive.IsZero() && !m.Time.Before(w.firstLive) {
+			continue
+		}
+		if n == 0 {
+			w.writeMsg("=history")
+		}
+		w.writePrivMsg(sender(m), lastArg(m), m.Time, m.Tags)
+		n++
+	}
+	if n > 0 {
+		w.writeMsg("=end of history")
+	}
+}
+
+// Sender returns the name shown for the sender of
+// a message. A message relayed by a bridge is shown
+// as sent by the relayed nick, followed by the
+// bridge's nick in parentheses.
+func sender(msg irc.Msg) string {
+	if msg.Relay != "" {
+		return msg.Origin + " (" + msg.Relay + ")"
+	}
+	return msg.Origin
+}
+
 // LastArg returns the last message
 // argument or the empty string if there
 // are no arguments.
@@ -641,18 +1250,6 @@ func lastArg(msg irc.Msg) string {
 	return msg.Args[len(msg.Args)-1]
 }
 
-// Exit marks all windows as clean and exits
-// with the given status.
-func exit(status int, why string) {
-	serverWin.WriteString(why)
-	serverWin.Ctl("clean")
-	for _, w := range wins {
-		w.WriteString(why)
-		w.Ctl("clean")
-	}
-	os.Exit(status)
-}
-
 func username() string {
 	un, err := osuser.Current()
 	if err != nil {
@@ -669,6 +1266,26 @@ func name() string {
 	return un.Name
 }
 
+// DumpArgs returns the command line arguments
+// without the password given by the -p flag,
+// which must not be written to the dump file.
+func dumpArgs(args []string) []string {
+	var d []string
+	for i := 0; i < len(args); i++ {
+		a := strings.TrimPrefix(args[i], "-")
+		switch {
+		case a == "-":
+			return append(d, args[i:]...)
+		case a == "p" || a == "-p":
+			i++ // skip the password
+		case strings.HasPrefix(a, "p=") || strings.HasPrefix(a, "-p="):
+		default:
+			d = append(d, args[i])
+		}
+	}
+	return d
+}
+
 // quote returns a single-quoted string, with interior
 // quotes quoted as ”.
 func quote(s string) string {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/velour/velour/irc"
+)
+
+// WatchTime is the interval at which watched
+// nicks are polled with ISON if the server
+// does not support MONITOR.
+const watchTime = 1 * time.Minute
+
+// A watched is a nick on the watch list.
+type watched struct {
+	nick string
+
+	// Known is true once the nick's
+	// online status has been learned.
+	known  bool
+	online bool
+}
+
+// SetWatchList sets the nicks on the watch list.
+func (s *session) setWatchList(nicks []string) {
+	for _, n := range nicks {
+		s.watching[strings.ToLower(n)] = &watched{nick: n}
+	}
+}
+
+// StartWatching starts watching the nicks
+// on the watch list on a new connection.
+// It should be called once the server has
+// sent RPL_ISUPPORT.
+func (s *session) startWatching() {
+	if len(s.watching) == 0 {
+		return
+	}
+	_, s.monitoring = s.client.ISupport(irc.MONITOR)
+	if !s.monitoring {
+		s.pollWatched()
+		return
+	}
+	var nicks []string
+	for _, w := range s.watching {
+		nicks = append(nicks, w.nick)
+	}
+	sort.Strings(nicks)
+	v, _ := s.client.ISupport(irc.MONITOR)
+	if max, err := strconv.Atoi(v); err == nil && max > 0 && len(nicks) > max {
+		s.serverWin.writeMsg("=ERROR: the server watches at most " + v +
+			" nicks; not watching " + strings.Join(nicks[max:], " "))
+		nicks = nicks[:max]
+	}
+	for _, m := range irc.MonitorMsgs(nicks) {
+		s.client.Out <- m
+	}
+}
+
+// DoMonListFull handles an ERR_MONLISTFULL
+// for the nicks that could not be watched.
+func (s *session) doMonListFull(limit, targets string) {
+	s.serverWin.writeMsg("=ERROR: the server's watch list is full at " + limit +
+		" nicks; not watching " + strings.ReplaceAll(targets, ",", " "))
+}
+
+// PollWatched sends an ISON for the watched
+// nicks if the server doesn't support MONITOR.
+func (s *session) pollWatched() {
+	if s.monitoring || len(s.watching) == 0 {
+		return
+	}
+	var nicks []string
+	for _, w := range s.watching {
+		nicks = append(nicks, w.nick)
+	}
+	sort.Strings(nicks)
+	// Replies not received by the next poll never will be.
+	s.isOn = nil
+	for _, m := range irc.IsOnMsgs(nicks) {
+		var asked []string
+		for _, n := range m.Args {
+			asked = append(asked, strings.ToLower(n))
+		}
+		s.isOn = append(s.isOn, asked)
+		s.client.Out <- m
+	}
+}
+
+// DoIsOn handles an RPL_ISON listing the online
+// nicks of those asked by the oldest unanswered ISON.
+func (s *session) doIsOn(nicks string) {
+	if len(s.isOn) == 0 {
+		return
+	}
+	asked := s.isOn[0]
+	s.isOn = s.isOn[1:]
+	on := map[string]bool{}
+	for _, n := range strings.Fields(nicks) {
+		on[strings.ToLower(n)] = true
+	}
+	for _, l := range asked {
+		if w, ok := s.watching[l]; ok {
+			s.setOnline(w, on[l])
+		}
+	}
+}
+
+// DoMonitor handles an RPL_MONONLINE or
+// RPL_MONOFFLINE for a comma-separated
+// list of targets.
+func (s *session) doMonitor(targets string, online bool) {
+	for _, t := range strings.Split(targets, ",") {
+		n, _, _ := strings.Cut(t, "!")
+		if w, ok := s.watching[strings.ToLower(n)]; ok {
+			s.setOnline(w, online)
+		}
+	}
+}
+
+// SetOnline records the online status of a watched nick,
+// reporting transitions in the server window and
+// the nick's private chat window, if it is open.
+// A watched nick's initial status is only reported
+// if it is online.
+func (s *session) setOnline(w *watched, online bool) {
+	if w.known && w.online == online {
+		return
+	}
+	report := w.known || online
+	w.known = true
+	w.online = online
+	if !report {
+		return
+	}
+	m := "=" + w.nick + " is offline"
+	if online {
+		m = "=" + w.nick + " is online"
+	}
+	s.serverWin.writeMsg(m)
+	if win, ok := s.wins[strings.ToLower(w.nick)]; ok {
+		win.writeMsg(m)
+	}
+	s.notifyWatch(w.nick, online)
+}
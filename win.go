@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -31,102 +32,506 @@ const (
 type win struct {
 	*acme.Win
 
+	// S is the session of the window.
+	s *session
+
 	// channel name or nick of chatter for this window.
 	target string
 
 	// Who is a list of users gathered by a who command.
 	who []string
 
+	// Cmds are the velour commands written to the tag.
+	cmds string
+
+	// Status is informational text written to the tag after cmds.
+	status string
+
 	users       map[string]*user
 	lastSpeaker string
 	lastTime    time.Time
 	stampTimer  *time.Timer
+
+	// UsersChanged is true if users has changed since
+	// it was last written to the window's users file.
+	usersChanged bool
+
+	// Stamped is true if a time stamp has been
+	// written since the message at lastTime.
+	stamped bool
+
+	// LastDelayed is true if the message at lastTime
+	// was received long after it was sent.
+	lastDelayed bool
+
+	// FirstLive is the time of the first message
+	// received since the window was created or
+	// the client reconnected.
+	firstLive time.Time
+
+	// Missed is true if messages may have been
+	// missed while disconnected.
+	missed bool
+
+	// Lines maps msgids to the latest messages
+	// written to the window that have them, at
+	// most maxLines, and order holds the same
+	// messages in the order of their offsets.
+	lines map[string]*line
+	order []*line
+
+	// ReplyTo is the msgid of the message to which
+	// the next message sent is a reply, if any,
+	// and replyWho is its sender.
+	replyTo, replyWho string
+
+	// Typers are the typing states of others.
+	typers map[string]typer
+
+	// TypingState is our typing state as last sent,
+	// typingSent is when it was sent, and lastKey
+	// is when we last typed.
+	typingState string
+	typingSent  time.Time
+	lastKey     time.Time
+	typingTimer *time.Timer
+
+	// Log is the log of the window's target,
+	// or nil if it is not logged.
+	log *chatLog
+
+	// Replaying is true while history is written
+	// to the window. Replayed messages are only
+	// logged if they are newer than the log.
+	replaying bool
+
+	// Files are the window's files in the
+	// file tree, or nil if there are none.
+	files *winFiles
+}
+
+// A line is a message written to the window,
+// which can be replied to, reacted to, or redacted.
+type line struct {
+	id, who, text string
+
+	// Q0 and q1 are the rune offsets of
+	// the message's text in the body.
+	q0, q1 int
+
+	// Reacts are the reactions to the message,
+	// written on the line after its text, and
+	// nreact is the number of runes written.
+	reacts []react
+	nreact int
+
+	redacted bool
+}
+
+// A react is a reaction and the
+// nicks that reacted with it.
+type react struct {
+	text string
+	who  []string
 }
 
 type user struct {
 	nick      string
 	origNick  string
 	changedAt time.Time
+
+	// Account is the user's services account name,
+	// or the empty string if unknown or not logged in.
+	account string
+
+	// Away is true if the user is away,
+	// and awayMsg is their away message.
+	away    bool
+	awayMsg string
 }
 
 type winEvent struct {
 	// TimeStamp is set to true for time stamp events. If timeStamp is true then Event is nil.
 	timeStamp bool
 
+	// TypingTimeout is set to true for typing timer events. If typingTimeout is true then Event is nil.
+	typingTimeout bool
+
 	*win
 	*acme.Event
 }
 
-func newWin(target string) *win {
+func newWin(s *session, target string) *win {
 	aw, err := acme.New()
 	if err != nil {
 		panic("Failed to create window: " + err.Error())
 	}
-	name := "/irc/" + server
+	name := "/irc/" + s.server
 	if target != "" {
 		name += "/" + target
 	}
-	aw.Name(name)
+	aw.Name("%s", name)
 	aw.Ctl("clean")
 	aw.Write("body", []byte(prompt))
-	if len(target) > 0 && target[0] == '#' {
-		aw.Fprintf("tag", "Who ")
+	cmds := "Reply React "
+	if target == "" {
+		cmds = "Chat Autojoin Search "
+	} else if target[0] == '#' {
+		cmds = "Who " + cmds
 	}
+	aw.Fprintf("tag", "%s", cmds)
 
 	w := &win{
 		Win:      aw,
+		s:        s,
 		target:   target,
+		cmds:     cmds,
 		users:    make(map[string]*user),
+		lines:    make(map[string]*line),
+		typers:   make(map[string]typer),
 		lastTime: time.Now(),
+		stamped:  true,
+	}
+	if target != "" && s.opts.logDir != "" {
+		w.log = openLog(s.logDir(target))
+	}
+	if s.opts.files != "" {
+		if f, err := openFiles(w, s.filesDir(target)); err != nil {
+			log.Println("Failed to open files: " + err.Error())
+		} else {
+			w.files = f
+		}
 	}
 	go func() {
 		for ev := range aw.EventChan() {
-			winEvents <- winEvent{false, w, ev}
+			winEvents <- winEvent{win: w, Event: ev}
 		}
 	}()
 	return w
 }
 
+// A bodyWriter is an io.Writer that
+// appends to the body of an acme window.
+type bodyWriter struct {
+	*acme.Win
+}
+
+func (w bodyWriter) Write(b []byte) (int, error) {
+	return w.Win.Write("body", b)
+}
+
 func (w *win) del() {
 	if w.stampTimer != nil {
 		w.stampTimer.Stop()
 	}
-	delete(wins, strings.ToLower(w.target))
+	if w.typingTimer != nil {
+		w.typingTimer.Stop()
+	}
+	if w.log != nil {
+		w.log.close()
+	}
+	if w.files != nil {
+		w.files.close()
+		w.files = nil
+	}
+	delete(w.s.wins, strings.ToLower(w.target))
 	w.Ctl("delete")
 }
 
+// SetStatus replaces the status text at the end of the window's tag.
+func (w *win) setStatus(status string) {
+	if status == w.status {
+		return
+	}
+	w.status = status
+	w.Ctl("cleartag")
+	w.Fprintf("tag", "%s%s", w.cmds, status)
+}
+
+// UpdateStatus sets the status of a chat window
+// to the message being replied to and who is typing.
+func (w *win) updateStatus() {
+	var s []string
+	if w.replyWho != "" {
+		s = append(s, "replying to "+w.replyWho)
+	}
+	if t := w.typingStatus(); t != "" {
+		s = append(s, t)
+	}
+	w.setStatus(strings.Join(s, "; "))
+}
+
 func (w *win) writeMsg(text string) {
 	w.WriteString(text)
 	w.lastSpeaker = ""
+	if !w.replaying {
+		w.record(time.Now(), text)
+	}
 }
 
-func (w *win) writePrivMsg(who, text string) {
-	s := w.privMsgString(who, text)
+// WriteScrollback writes the last n entries of the
+// window's log, between lines separating them from
+// other messages. Messages after the last entry are
+// then fetched from the server's history, if any.
+func (w *win) writeScrollback(n int) {
+	if w.log == nil || n <= 0 {
+		return
+	}
+	lines, err := w.log.tail(n)
+	if err != nil {
+		log.Println("Failed to read log: " + err.Error())
+	}
+	if len(lines) == 0 {
+		return
+	}
+	w.replaying = true
+	defer func() { w.replaying = false }()
+	w.writeMsg("=log")
+	for _, l := range lines {
+		switch e := parseLogLine(l); e.Kind {
+		case "message":
+			w.writePrivMsg(e.Nick, e.Text, l.t, e.Tags)
+		case "action":
+			w.writePrivMsg(e.Nick, actionPrefix+" "+e.Text+"\x01", l.t, e.Tags)
+		default:
+			w.writeMsg(l.text)
+		}
+	}
+	w.writeMsg("=end of log")
+	w.lastTime = w.log.last
+	w.missed = true
+}
+
+// Record writes an entry to the window's log, if any,
+// and, unless it is replayed, to its out file.
+// Live entries are logged no earlier than the latest
+// entry, so that the log stays in order.
+func (w *win) record(t time.Time, entry string) {
+	if w.files != nil && w.target != "" && !w.replaying {
+		w.files.write(t, entry)
+	}
+	if w.log == nil {
+		return
+	}
+	switch {
+	case w.replaying && !t.After(w.log.last):
+		return
+	case t.Before(w.log.last):
+		t = w.log.last
+	}
+	if err := w.log.write(t, entry); err != nil {
+		log.Println("Failed to write log: " + err.Error())
+	}
+}
+
+// WritePrivMsg writes a message sent by who at time t,
+// with the given tags. A reply is preceded by a quote
+// of the message to which it replies.
+func (w *win) writePrivMsg(who, text string, t time.Time, tags map[string]string) {
+	w.record(t, logMsg(who, text, tags))
+	if id := tags[irc.ReplyTag]; id != "" {
+		text = w.quoteLine(id) + "\n" + text
+	}
+	head, body := w.privMsgParts(who, text, t)
+	s := head + body
 	if *debug {
 		log.Printf("msg string=[%s]\nnum runes=%d\n", s,
 			utf8.RuneCountInString(s))
 	}
+	if id := tags[irc.IDTag]; id != "" && s != "" {
+		w.Addr(beforePrompt)
+		if q0, _, err := w.ReadAddr(); err == nil {
+			q0 += utf8.RuneCountInString(head)
+			w.addLine(&line{
+				id:   id,
+				who:  who,
+				text: text,
+				q0:   q0,
+				q1:   q0 + utf8.RuneCountInString(strings.TrimRight(body, "\n")),
+			})
+		}
+	}
 	w.WriteString(s)
 }
 
+// MaxLines is the number of the latest messages of a
+// window that can be replied to, reacted to, or redacted.
+const maxLines = 1000
+
+// AddLine records a message written at the end of the
+// body, forgetting the oldest if there are too many.
+func (w *win) addLine(l *line) {
+	if old, ok := w.lines[l.id]; ok {
+		i := w.lineIndex(old.q0)
+		w.order = append(w.order[:i], w.order[i+1:]...)
+	}
+	w.lines[l.id] = l
+	w.order = append(w.order, l)
+	if len(w.order) > maxLines {
+		delete(w.lines, w.order[0].id)
+		w.order[0] = nil
+		w.order = w.order[1:]
+	}
+}
+
+// LineIndex returns the index in order of the
+// first message at or after the rune offset q.
+func (w *win) lineIndex(q int) int {
+	return sort.Search(len(w.order), func(i int) bool { return w.order[i].q0 >= q })
+}
+
+// QuoteLine returns a quote of the
+// message with the given msgid.
+func (w *win) quoteLine(id string) string {
+	const maxQuote = 60
+	l, ok := w.lines[id]
+	switch {
+	case !ok:
+		return "> (unknown message)"
+	case l.redacted:
+		return "> " + l.who + ": (redacted)"
+	}
+	text, _, cut := strings.Cut(l.text, "\n")
+	if strings.HasPrefix(text, "> ") {
+		// Don't quote the quote of a reply.
+		text, _, cut = strings.Cut(l.text[len(text)+1:], "\n")
+	}
+	if r := []rune(text); len(r) > maxQuote {
+		text, cut = string(r[:maxQuote]), true
+	}
+	if cut {
+		text += "…"
+	}
+	return "> " + l.who + ": " + text
+}
+
+// LineAt returns the message written
+// at the rune offset q of the body.
+func (w *win) lineAt(q int) (*line, bool) {
+	i := w.lineIndex(q + 1)
+	if i == 0 {
+		return nil, false
+	}
+	l := w.order[i-1]
+	return l, q <= l.q1+l.nreact
+}
+
+// SelectedLine returns the message
+// containing the body's dot.
+func (w *win) selectedLine() (*line, bool) {
+	if err := w.Ctl("addr=dot"); err != nil {
+		return nil, false
+	}
+	q0, _, err := w.ReadAddr()
+	if err != nil {
+		return nil, false
+	}
+	return w.lineAt(q0)
+}
+
+// React records a reaction by who to the message
+// with the msgid, rewriting the message's reactions.
+func (w *win) react(id, who, text string) {
+	l, ok := w.lines[id]
+	if !ok || l.redacted {
+		return
+	}
+	i := 0
+	for i < len(l.reacts) && l.reacts[i].text != text {
+		i++
+	}
+	if i == len(l.reacts) {
+		l.reacts = append(l.reacts, react{text: text})
+	}
+	for _, n := range l.reacts[i].who {
+		if n == who {
+			return
+		}
+	}
+	l.reacts[i].who = append(l.reacts[i].who, who)
+
+	var rs []string
+	for _, r := range l.reacts {
+		rs = append(rs, "["+r.text+" "+strings.Join(r.who, " ")+"]")
+	}
+	s := "\n\t" + strings.Join(rs, " ")
+	w.replace(l.q1, l.q1+l.nreact, s, l)
+	l.nreact = utf8.RuneCountInString(s)
+}
+
+// Redact replaces the text of the message
+// with the msgid with a redaction notice.
+func (w *win) redact(id, who, reason string) {
+	l, ok := w.lines[id]
+	if !ok || l.redacted {
+		return
+	}
+	s := "(redacted by " + who
+	if reason != "" {
+		s += ": " + reason
+	}
+	s += ")"
+	w.replace(l.q0, l.q1+l.nreact, s, l)
+	l.redacted = true
+	l.q1 = l.q0 + utf8.RuneCountInString(s)
+	l.reacts, l.nreact = nil, 0
+}
+
+// Replace replaces the runes q0 through q1
+// of the body, which belong to the message l,
+// with s, moving the messages that follow.
+func (w *win) replace(q0, q1 int, s string, l *line) {
+	w.Addr("#%d,#%d", q0, q1)
+	w.writeData([]byte(s))
+	w.shift(q1, utf8.RuneCountInString(s)-(q1-q0), l)
+}
+
+// Shift moves the recorded offsets of the messages,
+// other than skip, that are at or after the rune
+// offset q by n runes.
+func (w *win) shift(q, n int, skip *line) {
+	if n == 0 {
+		return
+	}
+	for _, l := range w.order[w.lineIndex(q):] {
+		if l != skip {
+			l.q0 += n
+			l.q1 += n
+		}
+	}
+}
+
 const actionPrefix = "\x01ACTION"
 
-func (w *win) privMsgString(who, text string) string {
+// PrivMsgString returns the string for a message
+// sent by who at time t, preceded by time stamps
+// if needed.
+func (w *win) privMsgString(who, text string, t time.Time) string {
+	head, body := w.privMsgParts(who, text, t)
+	return head + body
+}
+
+// PrivMsgParts returns the string for a message
+// sent by who at time t split into the text of
+// the message and the time stamps and name
+// preceding it.
+func (w *win) privMsgParts(who, text string, t time.Time) (head, body string) {
 	if text == "\n" {
-		return ""
+		return "", ""
 	}
 	d("privMsgString [%s]\n", text)
 
+	stamp := w.stamp(t)
 	if strings.HasPrefix(text, actionPrefix) {
 		text = strings.TrimRight(text[len(actionPrefix):], "\x01")
 		if w.lastSpeaker != who {
 			w.lastSpeaker = ""
 		}
-		w.lastTime = time.Now()
-		return "*" + who + text
+		return stamp + "*" + who, text
 	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, 512))
+	buf.WriteString(stamp)
 
 	// Only print the user name if there is a new speaker or if two minutes has passed.
 	var sep = '\t'
@@ -150,27 +555,84 @@ func (w *win) privMsgString(who, text string) string {
 		sep = ' ' // only a space after their name.
 	}
 	w.lastSpeaker = who
-	w.lastTime = time.Now()
+
+	if who != w.s.nick && w.highlighted(text) {
+		buf.WriteRune('!')
+	}
+	buf.WriteRune(sep)
+
+	// Indent the lines of a multi-line message
+	// beneath the first.
+	if body := strings.TrimRight(text, "\n"); strings.Contains(body, "\n") {
+		text = strings.ReplaceAll(body, "\n", "\n\t") + text[len(body):]
+	}
+	return buf.String(), text
+}
+
+// Highlighted returns whether the text contains
+// our nick or one of the highlight words.
+func (w *win) highlighted(text string) bool {
+	words := []string{regexp.QuoteMeta(w.s.nick)}
+	for _, h := range w.s.opts.highlights {
+		words = append(words, regexp.QuoteMeta(h))
+	}
+	re := "(?i)(\\W|^)@?(" + strings.Join(words, "|") + ")(\\W|$)"
+	match, err := regexp.MatchString(re, text)
+	if err != nil {
+		fmt.Printf("regex [%s] failed: %s", re, err)
+	}
+	return err == nil && match
+}
+
+// Stamp records t as the time of the latest message,
+// returning the time stamps to write before it.
+//
+// If the message was delayed by more than stampTimeout,
+// as are messages replayed by a bouncer, then it is
+// preceded by a stamp of its own time, unless it closely
+// follows another delayed message.
+// Otherwise, if it follows the previous message by more
+// than stampTimeout and the previous message has not been
+// stamped, then it is preceded by a stamp of the previous
+// message's time, as would have been written had the
+// message been received in real time.
+func (w *win) stamp(t time.Time) string {
+	s := ""
+	delayed := time.Since(t) >= stampTimeout
+	gap := t.Sub(w.lastTime) >= stampTimeout
+	switch {
+	case delayed && (gap || !w.lastDelayed):
+		s = stampString(t) + "\n"
+		w.lastSpeaker = ""
+		w.stamped = true
+	case gap && !w.stamped:
+		s = stampString(w.lastTime) + "\n"
+		w.lastSpeaker = ""
+		w.stamped = false
+	default:
+		w.stamped = false
+	}
+	w.lastTime = t
+	w.lastDelayed = delayed
+
 	if w.stampTimer != nil {
 		w.stampTimer.Stop()
 	}
 	w.stampTimer = time.AfterFunc(stampTimeout, func() {
-		winEvents <- winEvent{true, w, nil}
+		winEvents <- winEvent{timeStamp: true, win: w}
 	})
+	return s
+}
 
-	if who != *nick {
-		re := "(\\W|^)@?" + *nick + "(\\W|$)"
-		match, err := regexp.MatchString(re, text)
-		if err != nil {
-			fmt.Printf("regex [%s] failed: %s", re, err)
-		}
-		if err == nil && match {
-			buf.WriteRune('!')
-		}
+// StampString returns the time stamp string for t.
+// The date is included if t is not today.
+func stampString(t time.Time) string {
+	t = t.Local()
+	y, m, d := t.Date()
+	if ny, nm, nd := time.Now().Date(); y != ny || m != nm || d != nd {
+		return t.Format("[Mon Jan 2 15:04:05]")
 	}
-	buf.WriteRune(sep)
-	buf.WriteString(text)
-	return buf.String()
+	return t.Format("[15:04:05]")
 }
 
 func (w *win) writeToPrompt(text string) {
@@ -185,6 +647,10 @@ func (w *win) WriteString(str string) {
 	d("write string [%s]\n", str)
 	w.Addr(beforePrompt)
 	w.writeData([]byte(str + "\n"))
+	// Chat windows write their out files as they log.
+	if w.files != nil && w.target == "" {
+		w.files.write(time.Now(), str)
+	}
 }
 
 // WriteData writes to the window data file, doesn't move the prompt pointers.
@@ -204,8 +670,12 @@ func (w *win) writeData(data []byte) {
 }
 
 func (w *win) printTimeStamp() {
+	if w.stamped {
+		return
+	}
+	w.stamped = true
 	w.lastSpeaker = ""
-	w.WriteString(w.lastTime.Format("[15:04:06]"))
+	w.WriteString(stampString(w.lastTime))
 }
 
 func (w *win) typing(q0, q1 int) {
@@ -223,6 +693,27 @@ func (w *win) typing(q0, q1 int) {
 	// addresses and the subsequent event (with the newline)
 	// appears to have inserted a newline before pAddr.
 	if r, _ := utf8.DecodeLastRune(text); r != '\n' {
+		// A command, or a message beginning with
+		// the // escape, is not announced as typing.
+		line := text[bytes.LastIndexByte(text, '\n')+1:]
+		if bytes.HasPrefix(line, []byte("/")) {
+			w.setTyping(irc.TypingDone)
+		} else {
+			w.setTyping(irc.TypingActive)
+		}
+		return
+	}
+
+	// Sending a message ends typing.
+	w.typingState = ""
+
+	// Send multiple lines as a single multi-line message
+	// if the server supports it.
+	lines := strings.Split(strings.TrimSuffix(string(text), "\n"), "\n")
+	if w != w.s.serverWin && len(lines) > 1 && w.s.client.HasCap(irc.MultilineCap) && !hasCmd(lines) {
+		d("lines=%q\n", lines)
+		w.Addr("%s,%s+#%d", beforePrompt, afterPrompt, utf8.RuneCount(text))
+		w.sendLines(lines)
 		return
 	}
 	for {
@@ -241,23 +732,46 @@ func (w *win) typing(q0, q1 int) {
 
 func (w *win) send(t string) {
 	d("sending [%s]\n", t)
-	if strings.HasPrefix(t, meCmd) {
-		act := strings.TrimLeft(t[len(meCmd):], " \t")
-		act = strings.TrimRight(act, "\n")
-		if act == "\n" {
+	name, args, isCmd := parseCmd(t)
+	switch {
+	case isCmd && (!strings.EqualFold("/"+name, meCmd) || w == w.s.serverWin):
+		w.writeData([]byte(strings.TrimRight(t, "\n") + prompt))
+		w.lastSpeaker = ""
+		w.runCmd(name, args)
+		return
+	case isCmd:
+		if args == "" {
 			t = "\n"
 		} else {
-			t = actionPrefix + " " + act + "\x01"
+			t = actionPrefix + " " + args + "\x01"
 		}
+	default:
+		t = chatText(t)
+	}
+
+	// With echo-message, our messages are
+	// written when the server echoes them.
+	echo := w != w.s.serverWin && w.s.client.HasCap(irc.EchoCap)
+	label := echo && w.s.client.HasCap(irc.LabelCap)
+
+	reply := w.replyTo
+	if reply != "" && t != "\n" {
+		w.replyTo, w.replyWho = "", ""
+		w.updateStatus()
 	}
 
 	msg := ""
-	if w == serverWin {
+	if w == w.s.serverWin {
 		if msg = t; msg == "\n" {
 			msg = ""
 		}
-	} else {
-		msg = w.privMsgString(*nick, t)
+	} else if !echo {
+		shown := t
+		if reply != "" {
+			shown = w.quoteLine(reply) + "\n" + t
+		}
+		msg = w.privMsgString(w.s.nick, shown, time.Now())
+		w.record(time.Now(), logMsg(w.s.nick, strings.TrimRight(t, "\n"), nil))
 
 		// Always tack on a newline.
 		// In the case of a /me command, the
@@ -274,33 +788,98 @@ func (w *win) send(t string) {
 	if t == "\n" {
 		return
 	}
-	if w == serverWin {
+	if w == w.s.serverWin {
 		t = strings.TrimLeft(t, " \t")
 		if msg, err := irc.ParseMsg(t); err != nil {
 			log.Println("Failed to parse message: " + err.Error())
 		} else {
-			client.Out <- msg
+			w.s.client.Out <- msg
 		}
 	} else {
-		for len(t) > 0 {
+		for _, p := range irc.SplitText(irc.PRIVMSG, w.target, strings.TrimRight(t, "\n")) {
 			m := irc.Msg{
-				Cmd:  "PRIVMSG",
-				Args: []string{w.target, t},
+				Cmd:  irc.PRIVMSG,
+				Args: []string{w.target, p},
+			}
+			if reply != "" {
+				m = irc.Reply(w.target, reply, p)
+				reply = ""
 			}
-			_, err := m.RawString()
-			if err != nil {
-				mtl := err.(irc.MsgTooLong)
-				m.Args[1] = t[:mtl.NTrunc]
-				t = t[mtl.NTrunc:]
-			} else {
-				t = ""
+			if label {
+				l := irc.NewLabel()
+				if m.Tags == nil {
+					m.Tags = map[string]string{}
+				}
+				m.Tags["label"] = l
+				w.s.pending[l] = pendingMsg{w, m.Args[1]}
 			}
-			client.Out <- m
+			w.s.client.Out <- m
 		}
 	}
 }
 
-func (w *win) deleting(q0, q1 int) { w.establishPrompt() }
+// HasCmd returns whether any of the lines is a command
+// or begins with a / that must be removed before sending.
+func hasCmd(lines []string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(l, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SendLines sends lines of text as draft/multiline batches.
+func (w *win) sendLines(lines []string) {
+	echo := w.s.client.HasCap(irc.EchoCap)
+	label := echo && w.s.client.HasCap(irc.LabelCap)
+
+	reply := w.replyTo
+	if reply != "" {
+		w.replyTo, w.replyWho = "", ""
+		w.updateStatus()
+	}
+
+	msg := ""
+	if !echo {
+		shown := strings.Join(lines, "\n")
+		if reply != "" {
+			shown = w.quoteLine(reply) + "\n" + shown
+		}
+		msg = w.privMsgString(w.s.nick, shown, time.Now())
+		w.record(time.Now(), logMsg(w.s.nick, strings.Join(lines, "\n"), nil))
+	}
+	w.writeData([]byte(msg + prompt))
+
+	v, _ := w.s.client.CapValue(irc.MultilineCap)
+	maxBytes, maxLines := irc.MultilineLimits(v)
+	for _, b := range irc.MultilineBatches(w.target, lines, maxBytes, maxLines) {
+		b[0].Tags = map[string]string{}
+		if reply != "" {
+			b[0].Tags[irc.ReplyTag] = reply
+			reply = ""
+		}
+		if label {
+			l := irc.NewLabel()
+			b[0].Tags["label"] = l
+			w.s.pending[l] = pendingMsg{w, irc.MultilineText(&irc.Batch{Msgs: b[1 : len(b)-1]})}
+		}
+		for _, m := range b {
+			w.s.client.Out <- m
+		}
+	}
+}
+
+func (w *win) deleting(q0, q1 int) {
+	w.establishPrompt()
+	if w.typingState == "" {
+		return
+	}
+	w.Addr(afterPrompt + ",$")
+	if text, err := w.ReadAll("data"); err == nil && len(text) == 0 {
+		w.setTyping(irc.TypingDone)
+	}
+}
 
 func (w *win) establishPrompt() {
 	w.Addr(promptAddr)